@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+// batchOperationInput is one entry in a POST /v1/movies/batch request. Movie
+// holds the same fields as a create or update request body; a delete needs
+// only the id. Version, when given, must match the movie's current version,
+// as the version in an If-Match ETag would.
+type batchOperationInput struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id"`
+	Version *int32          `json:"version"`
+	Movie   json.RawMessage `json:"movie"`
+}
+
+// batchResult is the outcome of one operation, with the status code and
+// error body the single-movie endpoint would have responded with.
+type batchResult struct {
+	Status int         `json:"status"`
+	Movie  *data.Movie `json:"movie,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+func batchValidationResult(errors map[string]string) *batchResult {
+	return &batchResult{Status: http.StatusUnprocessableEntity, Error: errors}
+}
+
+func batchNotFoundResult() *batchResult {
+	return &batchResult{Status: http.StatusNotFound, Error: "the response could not be found"}
+}
+
+func batchEditConflictResult() *batchResult {
+	return &batchResult{Status: http.StatusConflict, Error: "unable to update the record due to an edit conflict, please try again"}
+}
+
+func batchAbortedResult() *batchResult {
+	return &batchResult{Status: http.StatusFailedDependency, Error: "not applied because another operation in the batch failed"}
+}
+
+func (app *application) batchServerErrorResult(r *http.Request, err error) *batchResult {
+	app.logError(r, err)
+
+	return &batchResult{Status: http.StatusInternalServerError, Error: "the server encountered a problem and could not process your request"}
+}
+
+// batchMoviesHandler creates, updates and deletes movies in bulk. With
+// "atomic": true the operations run in a single transaction and either all of
+// them are applied or none; otherwise each is applied on its own and the rest
+// carry on past a failure. Updates are prepared against the movies as they
+// were before the batch, so two updates to the same movie conflict.
+func (app *application) batchMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     bool                  `json:"atomic"`
+		Operations []batchOperationInput `json:"operations"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Operations) > 0, "operations", "must contain at least one operation")
+	v.Check(len(input.Operations) <= app.Config.batch.maxOperations, "operations",
+		fmt.Sprintf("must not contain more than %d operations", app.Config.batch.maxOperations))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]*batchResult, len(input.Operations))
+	operations := []*data.MovieBatchOperation{}
+	positions := []int{}
+
+	for i, operation := range input.Operations {
+		prepared, result := app.prepareBatchOperation(r, operation)
+		if result != nil {
+			results[i] = result
+			continue
+		}
+
+		operations = append(operations, prepared)
+		positions = append(positions, i)
+	}
+
+	// An atomic batch with an operation that can't even be attempted is
+	// rejected without touching the database.
+	if input.Atomic && len(operations) < len(input.Operations) {
+		for _, i := range positions {
+			results[i] = batchAbortedResult()
+		}
+		operations = nil
+	}
+
+	if len(operations) > 0 {
+		errs, err := app.models.Movies.ApplyBatch(app.contextWithActor(r), operations, input.Atomic)
+		if err != nil {
+			app.serverStatusError(w, r, err)
+			return
+		}
+
+		for j, err := range errs {
+			results[positions[j]] = app.batchOperationResult(r, operations[j], err)
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+// prepareBatchOperation turns an operation from the request into the movie
+// write to run, checking it the way the single-movie handlers do. When the
+// operation can't be run it returns the result to report instead.
+func (app *application) prepareBatchOperation(r *http.Request, input batchOperationInput) (*data.MovieBatchOperation, *batchResult) {
+	v := validator.New()
+
+	hasMovie := len(input.Movie) > 0 && !bytes.Equal(input.Movie, []byte("null"))
+
+	switch input.Op {
+	case data.RevisionCreate:
+		var fields struct {
+			Title       string        `json:"title"`
+			Description string        `json:"description"`
+			Year        int32         `json:"year"`
+			Runtime     data.Runtime  `json:"runtime"`
+			Genres      []string      `json:"genres"`
+			Credits     []data.Credit `json:"credits"`
+		}
+
+		if !hasMovie {
+			v.AddErrors("movie", "must be provided")
+			return nil, batchValidationResult(v.Errors)
+		}
+
+		if result := decodeBatchMovie(input.Movie, &fields); result != nil {
+			return nil, result
+		}
+
+		movie := &data.Movie{
+			Title:       fields.Title,
+			Description: fields.Description,
+			Year:        fields.Year,
+			Runtime:     fields.Runtime,
+			Genres:      fields.Genres,
+			Credits:     fields.Credits,
+		}
+
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			return nil, batchValidationResult(v.Errors)
+		}
+
+		return &data.MovieBatchOperation{Action: data.RevisionCreate, Movie: movie}, nil
+
+	case data.RevisionUpdate:
+		var fields struct {
+			Title       *string       `json:"title"`
+			Description *string       `json:"description"`
+			Year        *int32        `json:"year"`
+			Runtime     *data.Runtime `json:"runtime"`
+			Genres      []string      `json:"genres"`
+		}
+
+		if !hasMovie {
+			v.AddErrors("movie", "must be provided")
+			return nil, batchValidationResult(v.Errors)
+		}
+
+		if result := decodeBatchMovie(input.Movie, &fields); result != nil {
+			return nil, result
+		}
+
+		movie, result := app.getBatchMovie(r, input)
+		if result != nil {
+			return nil, result
+		}
+
+		if fields.Title != nil {
+			movie.Title = *fields.Title
+		}
+		if fields.Description != nil {
+			movie.Description = *fields.Description
+		}
+		if fields.Year != nil {
+			movie.Year = *fields.Year
+		}
+		if fields.Runtime != nil {
+			movie.Runtime = *fields.Runtime
+		}
+		if fields.Genres != nil {
+			movie.Genres = fields.Genres
+		}
+
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			return nil, batchValidationResult(v.Errors)
+		}
+
+		return &data.MovieBatchOperation{Action: data.RevisionUpdate, Movie: movie}, nil
+
+	case data.RevisionDelete:
+		if hasMovie {
+			v.AddErrors("movie", "must not be provided for a delete")
+			return nil, batchValidationResult(v.Errors)
+		}
+
+		movie := &data.Movie{ID: input.ID}
+
+		// The movie's version goes into the delete as well, so a write that
+		// lands after this check still makes the delete conflict.
+		if input.Version != nil {
+			var result *batchResult
+
+			movie, result = app.getBatchMovie(r, input)
+			if result != nil {
+				return nil, result
+			}
+		}
+
+		return &data.MovieBatchOperation{Action: data.RevisionDelete, Movie: movie}, nil
+
+	default:
+		v.AddErrors("op", "must be one of create, update or delete")
+		return nil, batchValidationResult(v.Errors)
+	}
+}
+
+// getBatchMovie loads the movie an update or delete refers to and checks the
+// operation's version against it.
+func (app *application) getBatchMovie(r *http.Request, input batchOperationInput) (*data.Movie, *batchResult) {
+	movie, err := app.models.Movies.Get(r.Context(), input.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			return nil, batchNotFoundResult()
+		default:
+			return nil, app.batchServerErrorResult(r, err)
+		}
+	}
+
+	if input.Version != nil && *input.Version != movie.Version {
+		return nil, batchEditConflictResult()
+	}
+
+	return movie, nil
+}
+
+// decodeBatchMovie reads an operation's movie fields, rejecting unknown keys
+// as readJSON does for a whole request body.
+func decodeBatchMovie(raw json.RawMessage, dst interface{}) *batchResult {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		v := validator.New()
+		v.AddErrors("movie", fmt.Sprintf("is not a valid movie: %s", strings.TrimPrefix(err.Error(), "json: ")))
+		return batchValidationResult(v.Errors)
+	}
+
+	return nil
+}
+
+// batchOperationResult reports how a write that reached the database went.
+func (app *application) batchOperationResult(r *http.Request, operation *data.MovieBatchOperation, err error) *batchResult {
+	switch {
+	case err == nil:
+		switch operation.Action {
+		case data.RevisionCreate:
+			return &batchResult{Status: http.StatusCreated, Movie: operation.Movie}
+		case data.RevisionUpdate:
+			return &batchResult{Status: http.StatusOK, Movie: operation.Movie}
+		default:
+			return &batchResult{Status: http.StatusOK}
+		}
+
+	case errors.Is(err, data.ErrorBatchAborted):
+		return batchAbortedResult()
+
+	case errors.Is(err, data.ErrorUnknownPerson):
+		return batchValidationResult(map[string]string{"credits": "must reference existing people"})
+
+	case errors.Is(err, data.ErrorRecordNotFound):
+		return batchNotFoundResult()
+
+	case errors.Is(err, data.ErrorEditConflict):
+		return batchEditConflictResult()
+
+	default:
+		return app.batchServerErrorResult(r, err)
+	}
+}
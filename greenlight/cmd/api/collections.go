@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+var collectionMovieSortSafelist = append([]string{"position", "-position"}, watchlistSortSafelist...)
+
+// readCollection loads the collection named in the URL. Private collections
+// are reported as not found to anyone but their owner, and when ownerOnly is
+// set other users get a 403 for public ones too. It writes the error response
+// itself and returns nil when the collection can't be used.
+func (app *application) readCollection(w http.ResponseWriter, r *http.Request, ownerOnly bool) *data.Collection {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	collection, err := app.models.Collections.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return nil
+	}
+
+	isOwner := collection.UserID == app.contextGetUser(r).ID
+
+	switch {
+	case !isOwner && !collection.Public:
+		app.notFoundResponse(w, r)
+		return nil
+	case !isOwner && ownerOnly:
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+
+	if !isOwner {
+		collection.ShareToken = ""
+	}
+
+	return collection
+}
+
+// readCollectionMoviesPage reads and validates the pagination and sort
+// parameters for a collection's movies.
+func (app *application) readCollectionMoviesPage(r *http.Request, v *validator.Validator) data.Filters {
+	qs := r.URL.Query()
+
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         app.readString(qs, "sort", "position"),
+		SortSafelist: collectionMovieSortSafelist,
+	}
+
+	data.ValidateFilters(v, filters)
+
+	return filters
+}
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		UserID:      app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: input.Description,
+		Public:      input.Public,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(r.Context(), collection)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"collection": collection}, headers)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, false)
+	if collection == nil {
+		return
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+	if input.Public != nil {
+		collection.Public = *input.Public
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(r.Context(), collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	err := app.models.Collections.Delete(r.Context(), collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+// rotateCollectionShareTokenHandler issues a new share link for the
+// collection, revoking the old one.
+func (app *application) rotateCollectionShareTokenHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	err := app.models.Collections.RotateShareToken(r.Context(), collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) listUserCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collections, metadata, err := app.models.Collections.GetAllForUser(r.Context(), app.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) listCollectionMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, false)
+	if collection == nil {
+		return
+	}
+
+	v := validator.New()
+
+	filters := app.readCollectionMoviesPage(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Collections.GetMovies(r.Context(), collection.ID, filters)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) addCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	var input struct {
+		MovieID int64 `json:"movie_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(input.MovieID > 0, "movie_id", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.AddMovie(r.Context(), collection.ID, input.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			v.AddErrors("movie_id", "must reference an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"message": "movie added to collection"}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) removeCollectionMovieHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Collections.RemoveMovie(r.Context(), collection.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "movie removed from collection"}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) reorderCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	var input struct {
+		MovieIDs []int64 `json:"movie_ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.MovieIDs != nil, "movie_ids", "must be provided")
+	v.Check(validator.Unique(input.MovieIDs), "movie_ids", "must not contain duplicate values")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Reorder(r.Context(), collection.ID, input.MovieIDs)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorInvalidOrder):
+			v.AddErrors("movie_ids", "must list every movie in the collection exactly once")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "collection reordered"}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+// showSharedCollectionHandler serves a collection, public or private, to
+// anyone holding its share link, along with a page of its movies.
+func (app *application) showSharedCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+
+	filters := app.readCollectionMoviesPage(r, v)
+	if data.ValidateShareToken(v, token); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	collection, err := app.models.Collections.GetByShareToken(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	movies, metadata, err := app.models.Collections.GetMovies(r.Context(), collection.ID, filters)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	collection.ShareToken = ""
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"collection": collection, "movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"greenlight.rasulabduvaitov.net/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey         = contextKey("user")
+	requestInfoContextKey  = contextKey("requestInfo")
+	maxBodyBytesContextKey = contextKey("maxBodyBytes")
+)
+
+// defaultMaxBodyBytes is how large a request body readJSON accepts on routes
+// that don't set their own limit with maxBodySize.
+const defaultMaxBodyBytes = 1_048_576
+
+// requestInfo is shared by pointer between the outer logging middleware and
+// the handlers it wraps, so values discovered further down the chain (such as
+// the authenticated user or the matched route) end up in the access log line
+// and the request metrics.
+type requestInfo struct {
+	id     string
+	userID int64
+	route  string
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+
+	return info
+}
+
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if !user.IsAnonymous() {
+		app.contextGetRequestInfo(r).userID = user.ID
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// contextWithActor returns the request's context carrying the user and
+// request ID that movie revisions are attributed to.
+func (app *application) contextWithActor(r *http.Request) context.Context {
+	info := app.contextGetRequestInfo(r)
+
+	return data.ContextWithActor(r.Context(), data.Actor{UserID: info.userID, RequestID: info.id})
+}
+
+func (app *application) contextSetMaxBodyBytes(r *http.Request, n int64) *http.Request {
+	ctx := context.WithValue(r.Context(), maxBodyBytesContextKey, n)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetMaxBodyBytes(r *http.Request) int64 {
+	n, ok := r.Context().Value(maxBodyBytesContextKey).(int64)
+	if !ok {
+		return defaultMaxBodyBytes
+	}
+
+	return n
+}
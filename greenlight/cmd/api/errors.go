@@ -5,17 +5,39 @@ import (
 	"net/http"
 )
 
+// requestProperties adds the request's ID to properties, so that every line
+// logged while handling a request can be tied back to it.
+func (app *application) requestProperties(r *http.Request, properties map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{
+		"request_id": app.contextGetRequestInfo(r).id,
+	}
+
+	for key, value := range properties {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+func (app *application) logInfo(r *http.Request, message string, properties map[string]interface{}) {
+	app.logger.PrintInfo(message, app.requestProperties(r, properties))
+}
+
+func (app *application) logWarn(r *http.Request, message string, properties map[string]interface{}) {
+	app.logger.PrintWarn(message, app.requestProperties(r, properties))
+}
+
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	app.logger.PrintError(err, app.requestProperties(r, map[string]interface{}{
 		"request_method": r.Method,
 		"request_url": r.URL.String(),
-	})
+	}))
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int,
 	massage interface{}) {
 	env := envelope{"error": massage}
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -23,7 +45,7 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 func (app *application) serverStatusError(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.PrintError(err, nil)
+	app.logError(r, err)
 
 	massage := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, massage)
@@ -52,8 +74,55 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since the version given in If-Match"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, accepted string) {
+	message := fmt.Sprintf("the request body must be one of %s", accepted)
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this Idempotency-Key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, message)
+}
+
+func (app *application) idempotencyKeyInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this Idempotency-Key is still being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request){
 	message := "rate limit exidet"
 	app.errorResponse(w,r, http.StatusTooManyRequests, message)
-}
\ No newline at end of file
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
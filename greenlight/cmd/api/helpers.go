@@ -10,6 +10,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
 	"github.com/julienschmidt/httprouter"
 	"greenlight.rasulabduvaitov.net/internal/validator"
 )
@@ -17,18 +19,37 @@ import (
 type envelope map[string]interface{}
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// readNamedIDParam reads a positive id from the named route parameter, for
+// routes with more than one id such as /v1/movies/:id/reviews/:review_id.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int,
+// writeJSON sends data as the response body. When a successful GET carries
+// an ETag or Last-Modified header and the request's If-None-Match or
+// If-Modified-Since shows the client already has this version, it sends 304
+// Not Modified with no body instead.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int,
 	data envelope, header http.Header) error {
+	if status == http.StatusOK && r.Method == http.MethodGet && notModified(r, header) {
+		for key, value := range header {
+			w.Header()[key] = value
+		}
+
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
 		return err
@@ -54,10 +75,75 @@ func (app *application) writeJSON(w http.ResponseWriter, status int,
 	return nil
 }
 
+// notModified evaluates the request's If-None-Match, or failing that its
+// If-Modified-Since, against the validators in header, as RFC 7232 orders
+// them.
+func notModified(r *http.Request, header http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		return etag != "" && etagMatches(ifNoneMatch, etag, true)
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+
+		return !lastModified.After(since)
+	}
+
+	return false
+}
+
+// etagMatches reports whether etag is in an If-Match or If-None-Match list,
+// where "*" matches anything. If-None-Match compares weakly, ignoring a W/
+// prefix; If-Match compares strongly, so weak tags never match it.
+func etagMatches(list, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		switch {
+		case candidate == "*":
+			return true
+		case strings.HasPrefix(candidate, "W/"):
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkIfMatch enforces the request's If-Match header, if it has one, against
+// the current ETag of the resource about to be changed. It sends 412
+// Precondition Failed and returns false when the client's copy is stale.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+
+	if ifMatch != "" && !etagMatches(ifMatch, etag, false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 
-	maxBites := 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBites))
+	maxBites := app.contextGetMaxBodyBytes(r)
+	r.Body = http.MaxBytesReader(w, r.Body, maxBites)
 
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
@@ -153,18 +239,63 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 }
 
 
-func (app *application) background(fn func()){
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddErrors(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddErrors(key, "must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+	return time.Time{}
+}
+
+
+// background runs fn in a goroutine that graceful shutdown waits for. r is the
+// request that started the task, so its ID ends up in any error logged; pass
+// nil for tasks that aren't tied to a request.
+func (app *application) background(r *http.Request, fn func()){
 
 	app.wg.Add(1)
+	app.backgroundTasks.Add(1)
 
 	go func() {
 
 		defer app.wg.Done()
+		defer app.backgroundTasks.Add(-1)
 
 		defer func() {
 
 			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s\n",err), nil)
+				if r != nil {
+					app.logError(r, fmt.Errorf("%s", err))
+				} else {
+					app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				}
 			}
 
 		}()
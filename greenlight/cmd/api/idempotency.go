@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+// idempotencyCleanupInterval is how often expired idempotency keys are
+// deleted.
+const idempotencyCleanupInterval = time.Hour
+
+// idempotentHeaders are the response headers kept for a replay. Others, such
+// as the CORS headers and X-Request-ID, describe the original request and are
+// set afresh for the retry.
+var idempotentHeaders = []string{"Content-Type", "ETag", "Last-Modified", "Location"}
+
+// copyIdempotentHeaders copies the headers in idempotentHeaders from src to
+// dst.
+func copyIdempotentHeaders(dst, src http.Header) {
+	for _, name := range idempotentHeaders {
+		if values := src.Values(name); len(values) > 0 {
+			dst[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+}
+
+// idempotencyRecorder passes a response through to the client while keeping a
+// copy of it to store against the request's Idempotency-Key.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode    int
+	header        http.Header
+	body          bytes.Buffer
+	headerWritten bool
+}
+
+func (rw *idempotencyRecorder) WriteHeader(statusCode int) {
+	if !rw.headerWritten {
+		rw.statusCode = statusCode
+		rw.header = make(http.Header)
+		copyIdempotentHeaders(rw.header, rw.ResponseWriter.Header())
+		rw.headerWritten = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *idempotencyRecorder) Write(b []byte) (int, error) {
+	if !rw.headerWritten {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// idempotent makes a POST handler safe to retry. When the request carries an
+// Idempotency-Key header, the first request with that key is handled as
+// usual and its response stored; retries with the same method, URL and body
+// get the stored response back instead of running the handler again. Keys
+// are scoped to the authenticated user, or to the client's IP address for
+// anonymous requests.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		if data.ValidateIdempotencyKey(v, key); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// Anything past readJSON's limit is rejected by the handler anyway,
+		// so there is no need to buffer more than one byte beyond it.
+		body, err := io.ReadAll(io.LimitReader(r.Body, app.contextGetMaxBodyBytes(r)+1))
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		fingerprint := hash.Sum(nil)
+
+		// Anonymous clients are told apart by address, the way the rate
+		// limiter does, so one can't replay another's response.
+		var owner string
+		if user := app.contextGetUser(r); !user.IsAnonymous() {
+			owner = fmt.Sprintf("user:%d", user.ID)
+		} else {
+			ip, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				ip = r.RemoteAddr
+			}
+			owner = "anonymous:" + ip
+		}
+
+		stored, err := app.models.Idempotency.Begin(r.Context(), owner, key, fingerprint, app.Config.idempotency.ttl, app.Config.idempotency.lease)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrorIdempotencyKeyMismatch):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case errors.Is(err, data.ErrorIdempotencyKeyInUse):
+				app.idempotencyKeyInUseResponse(w, r)
+			default:
+				app.serverStatusError(w, r, err)
+			}
+			return
+		}
+
+		if stored != nil {
+			copyIdempotentHeaders(w.Header(), stored.Header)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		// The key is released if the handler fails with a server error or
+		// panics, so the client's retry gets a fresh attempt rather than a
+		// replay of the failure. The client may have gone away by the time
+		// the response is stored, so its cancellation is ignored.
+		ctx := context.WithoutCancel(r.Context())
+		rw := &idempotencyRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		completed := false
+
+		defer func() {
+			if completed {
+				return
+			}
+
+			err := app.models.Idempotency.Release(ctx, owner, key)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next(rw, r)
+
+		if rw.statusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		// If the response can't be stored the key stays claimed until it
+		// expires: answering retries with 409 beats repeating the request.
+		completed = true
+
+		err = app.models.Idempotency.Complete(ctx, owner, key, &data.IdempotentResponse{
+			StatusCode: rw.statusCode,
+			Header:     rw.header,
+			Body:       rw.body.Bytes(),
+		})
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+// expireIdempotencyKeys deletes idempotency keys past their TTL every
+// idempotencyCleanupInterval until stop is closed, the same way purgeTrash
+// runs. The returned channel is closed once no more runs will be started.
+func (app *application) expireIdempotencyKeys(stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(idempotencyCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				app.background(nil, func() {
+					deleted, err := app.models.Idempotency.DeleteExpired(context.Background())
+					if err != nil {
+						app.logger.PrintError(err, map[string]interface{}{
+							"job": "expire_idempotency_keys",
+						})
+						return
+					}
+
+					if deleted > 0 {
+						app.logger.PrintInfo("deleted expired idempotency keys", map[string]interface{}{
+							"count": deleted,
+						})
+					}
+				})
+			}
+		}
+	}()
+
+	return done
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+
+	"greenlight.rasulabduvaitov.net/internal/metrics"
+)
+
+type appMetrics struct {
+	registry         *metrics.Registry
+	requests         *metrics.CounterVec
+	requestDuration  *metrics.HistogramVec
+	rateLimitRejects *metrics.CounterVec
+}
+
+func (app *application) newMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.NewCounter("greenlight_http_requests_total",
+			"Total number of HTTP requests processed.", "method", "route", "status"),
+		requestDuration: registry.NewHistogram("greenlight_http_request_duration_seconds",
+			"HTTP request latency in seconds.", metrics.DefaultBuckets, "method", "route"),
+		rateLimitRejects: registry.NewCounter("greenlight_rate_limit_rejections_total",
+			"Total number of requests rejected by the rate limiter."),
+	}
+
+	registry.NewGaugeFunc("greenlight_background_tasks",
+		"Number of background goroutines currently running.", func() float64 {
+			return float64(app.backgroundTasks.Load())
+		})
+
+	registry.NewCounterFunc("greenlight_mailer_sent_total",
+		"Total number of emails sent successfully.", func() float64 {
+			sent, _ := app.mailer.Stats()
+			return float64(sent)
+		})
+	registry.NewCounterFunc("greenlight_mailer_failed_total",
+		"Total number of emails that failed to send.", func() float64 {
+			_, failed := app.mailer.Stats()
+			return float64(failed)
+		})
+
+	if db != nil {
+		registry.NewGaugeFunc("greenlight_db_max_open_connections",
+			"Maximum number of open connections to the database.", func() float64 {
+				return float64(db.Stats().MaxOpenConnections)
+			})
+		registry.NewGaugeFunc("greenlight_db_open_connections",
+			"Number of established connections, both in use and idle.", func() float64 {
+				return float64(db.Stats().OpenConnections)
+			})
+		registry.NewGaugeFunc("greenlight_db_in_use_connections",
+			"Number of connections currently in use.", func() float64 {
+				return float64(db.Stats().InUse)
+			})
+		registry.NewGaugeFunc("greenlight_db_idle_connections",
+			"Number of idle connections.", func() float64 {
+				return float64(db.Stats().Idle)
+			})
+		registry.NewCounterFunc("greenlight_db_wait_count_total",
+			"Total number of connections waited for.", func() float64 {
+				return float64(db.Stats().WaitCount)
+			})
+		registry.NewCounterFunc("greenlight_db_wait_duration_seconds_total",
+			"Total time blocked waiting for a new connection.", func() float64 {
+				return db.Stats().WaitDuration.Seconds()
+			})
+	}
+
+	return m
+}
+
+// routeLabel records the pattern a handler is registered under, such as
+// /v1/movies/:id, so metrics aren't labelled with every distinct URL.
+func (app *application) routeLabel(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		app.contextGetRequestInfo(r).route = pattern
+		next(w, r)
+	}
+}
+
+// metricsHandler serves the metrics in Prometheus text format. They show
+// traffic per route and mailer failures, so the route needs the metrics:read
+// permission; a scraper authenticates with a user's token like any client.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	_, err := app.metrics.registry.WriteTo(w)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
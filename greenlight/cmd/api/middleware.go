@@ -1,13 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/validator"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -73,6 +80,7 @@ func (app *application) reteLimit(next http.Handler) http.Handler {
 		clients[ip].lastseen = time.Now()
 		if !clients[ip].limiter.Allow() {
 		mu.Unlock()
+		app.metrics.rateLimitRejects.Inc()
 		app.rateLimitExceededResponse(w, r)
 		return
 				}
@@ -81,3 +89,270 @@ func (app *application) reteLimit(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlainText(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrorRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverStatusError(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			app.serverStatusError(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
+
+// maxBodySize raises (or lowers) the request body limit readJSON enforces for
+// one route from the default of defaultMaxBodyBytes to n bytes.
+func (app *application) maxBodySize(n int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, app.contextSetMaxBodyBytes(r, n))
+	}
+}
+
+
+// responseRecorder captures the status code and body size written by the
+// handlers further down the chain.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode    int
+	bytesWritten  int
+	headerWritten bool
+}
+
+func (rw *responseRecorder) WriteHeader(statusCode int) {
+	if !rw.headerWritten {
+		rw.statusCode = statusCode
+		rw.headerWritten = true
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	if !rw.headerWritten {
+		rw.statusCode = http.StatusOK
+		rw.headerWritten = true
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytesWritten += n
+	return n, err
+}
+
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// recordMetrics counts and times requests by the route routeLabel recorded.
+// Requests the router couldn't match are labelled unmatched, and those answered
+// before routing, such as rate limited ones, unrouted.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rw := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		route := app.contextGetRequestInfo(r).route
+		if route == "" {
+			route = "unrouted"
+		}
+
+		app.metrics.requests.Inc(r.Method, route, strconv.Itoa(rw.statusCode))
+		app.metrics.requestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+
+// validRequestID reports whether an X-Request-ID supplied by the client is
+// safe to echo back and write to the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverStatusError(w, r, err)
+				return
+			}
+
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestInfo(r, &requestInfo{id: id})
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		rw := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			clientIP = r.RemoteAddr
+		}
+
+		info := app.contextGetRequestInfo(r)
+
+		properties := map[string]interface{}{
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"status":         rw.statusCode,
+			"bytes":          rw.bytesWritten,
+			"duration_ms":    float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip":      clientIP,
+		}
+
+		if info.userID != 0 {
+			properties["user_id"] = info.userID
+		}
+
+		app.logInfo(r, "request completed", properties)
+	})
+}
+
+
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		w.Header().Add("Vary", "Origin")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+
+		origin := r.Header.Get("Origin")
+
+		if origin != "" {
+			for i := range app.Config.cors.trustedOrigins {
+				if origin != app.Config.cors.trustedOrigins[i] {
+					continue
+				}
+
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", "ETag, Idempotent-Replayed, Last-Modified, Location, X-Request-ID")
+
+				// Preflight requests are answered here, before they reach
+				// httprouter's automatic OPTIONS handling or the rate limiter.
+				if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE, GET, POST")
+					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, If-Modified-Since, X-Request-ID")
+					w.Header().Set("Access-Control-Max-Age", "600")
+
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+
+				break
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"greenlight.rasulabduvaitov.net/internal/migrate"
+	"greenlight.rasulabduvaitov.net/migrations"
+)
+
+func newMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	return migrate.New(db, migrations.FS)
+}
+
+// runMigrate handles `greenlight migrate up|down [N]|status|goto N`.
+func (app *application) runMigrate(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down [N]|status|goto N")
+	}
+
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	var applied []migrate.Migration
+
+	switch args[0] {
+	case "up":
+		applied, err = migrator.Up(ctx)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		applied, err = migrator.Down(ctx, steps)
+
+	case "goto":
+		if len(args) < 2 {
+			return errors.New("usage: migrate goto N")
+		}
+		target, perr := strconv.ParseInt(args[1], 10, 64)
+		if perr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		applied, err = migrator.Goto(ctx, target)
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%06d  %-8s  %s\n", s.Version, state, s.Name)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	for _, m := range applied {
+		app.logger.PrintInfo("migration applied", map[string]interface{}{
+			"command": args[0],
+			"version": m.Version,
+			"name":    m.Name,
+		})
+	}
+
+	switch {
+	case errors.Is(err, migrate.ErrNoChange):
+		app.logger.PrintInfo("no migrations to apply", nil)
+		return nil
+	default:
+		return err
+	}
+}
+
+// checkSchema refuses to start the server when the database hasn't been
+// migrated to the latest version embedded in the binary.
+func checkSchema(db *sql.DB) error {
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	current, err := migrator.Version(context.Background())
+	if err != nil {
+		return err
+	}
+
+	if current < migrator.Latest() {
+		return fmt.Errorf("database schema is at version %d but version %d is required, run `migrate up`", current, migrator.Latest())
+	}
+
+	return nil
+}
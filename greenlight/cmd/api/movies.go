@@ -1,11 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
 	"greenlight.rasulabduvaitov.net/internal/data"
 	"greenlight.rasulabduvaitov.net/internal/validator"
@@ -13,10 +16,12 @@ import (
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title       string        `json:"title"`
+		Description string        `json:"description"`
+		Year        int32         `json:"year"`
+		Runtime     data.Runtime  `json:"runtime"`
+		Genres      []string      `json:"genres"`
+		Credits     []data.Credit `json:"credits"`
 	}
 	err := app.readJSON(w, r, &input)
 	if err != nil {
@@ -25,10 +30,12 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:       input.Title,
+		Description: input.Description,
+		Year:        input.Year,
+		Runtime:     input.Runtime,
+		Genres:      input.Genres,
+		Credits:     input.Credits,
 	}
 	v := validator.New()
 	if data.ValidateMovie(v, movie); !v.Valid() {
@@ -36,17 +43,23 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(app.contextWithActor(r), movie)
 	if err != nil {
-		log.Printf("Error inserting %s", err)
-		app.serverStatusError(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrorUnknownPerson):
+			v.AddErrors("credits", "must reference existing people")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			log.Printf("Error inserting %s", err)
+			app.serverStatusError(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverStatusError(w, r, err)
 	}
@@ -59,7 +72,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrorRecordNotFound):
@@ -70,10 +83,77 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers, err := movieHeaders(movie)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+// jsonETag is a strong validator for v: a hash of its JSON encoding, so the
+// tag changes whenever anything the client would see does, including values
+// such as the rating aggregates that change without a new version.
+func jsonETag(v interface{}) (string, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+
+	return fmt.Sprintf(`"%x"`, sum[:16]), nil
+}
+
+func movieHeaders(movie *data.Movie) (http.Header, error) {
+	etag, err := jsonETag(movie)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	// HTTP dates only carry whole seconds, so a Last-Modified handed out
+	// during the second it names could be followed by another write in that
+	// same second, which If-Modified-Since would then miss. It is only sent
+	// once that second is over.
+	if movie.UpdatedAt.Before(time.Now().Truncate(time.Second)) {
+		headers.Set("Last-Modified", movie.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+
+	return headers, nil
+}
+
+// movieListHeaders tags a page of movies with a hash of the whole page,
+// metadata and cursors included, since cursors are signed with a key that may
+// not survive a restart. There is no Last-Modified, since a movie leaving the
+// page doesn't make the page's newest updated_at any later.
+func movieListHeaders(movies []*data.Movie, metadata data.Metadata) (http.Header, error) {
+	etag, err := jsonETag(envelope{"movies": movies, "metadata": metadata})
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	return headers, nil
+}
+
+// checkMovieIfMatch is checkIfMatch against the movie's current ETag.
+func (app *application) checkMovieIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	etag, err := jsonETag(movie)
 	if err != nil {
 		app.serverStatusError(w, r, err)
+		return false
 	}
+
+	return app.checkIfMatch(w, r, etag)
 }
 
 
@@ -84,7 +164,7 @@ func (app *application) updateMovieHendler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrorRecordNotFound):
@@ -95,34 +175,12 @@ func (app *application) updateMovieHendler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("X-Expected-Version") {
-			app.editConflictResponse(w, r)
-			return
-		}
-	}
-
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestError(w, r, err)
+	if !app.checkMovieIfMatch(w, r, movie) {
 		return
 	}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	if !app.applyMoviePatch(w, r, movie) {
+		return
 	}
 
 	v := validator.New()
@@ -132,9 +190,13 @@ func (app *application) updateMovieHendler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(app.contextWithActor(r), movie)
 	if err != nil {
 		switch {
+		// A write that lands after the If-Match check means the client's
+		// precondition no longer holds, as deleteMovieHandler reports it.
+		case errors.Is(err, data.ErrorEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrorEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -143,7 +205,13 @@ func (app *application) updateMovieHendler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	headers, err := movieHeaders(movie)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != err {
 		app.serverStatusError(w, r, err)
 
@@ -160,9 +228,34 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	// With If-Match the delete only goes ahead at the version that matched,
+	// so a write that lands in between is not trashed unseen.
+	var version int32
+
+	if r.Header.Get("If-Match") != "" {
+		movie, err := app.models.Movies.Get(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrorRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverStatusError(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkMovieIfMatch(w, r, movie) {
+			return
+		}
+
+		version = movie.Version
+	}
+
+	err = app.models.Movies.Delete(app.contextWithActor(r), id, version)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrorEditConflict):
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, data.ErrorRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -171,7 +264,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"massage": "Movie delete sccsessfuly"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"massage": "Movie delete sccsessfuly"}, nil)
 	if err != nil {
 		app.serverStatusError(w, r, err)
 	}
@@ -182,8 +275,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request) {
 
 	var input struct {
-		Title    string
-		Genres   []string
+		data.MovieFilter
 		data.Filters
 	}
 
@@ -192,16 +284,39 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
+	input.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+	input.Search = app.readString(qs, "q", "")
+	input.Language = app.readString(qs, "language", "simple")
+	input.Highlight = app.readBool(qs, "highlight", false, v)
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.AnyGenres = app.readCSV(qs, "any_genre", []string{})
+	input.ExcludeGenres = app.readCSV(qs, "exclude_genre", []string{})
+
+	input.YearMin = app.readInt(qs, "year_min", 0, v)
+	input.YearMax = app.readInt(qs, "year_max", 0, v)
+	input.RuntimeMin = app.readInt(qs, "runtime_min", 0, v)
+	input.RuntimeMax = app.readInt(qs, "runtime_max", 0, v)
+	input.CreatedAfter = app.readTime(qs, "created_after", v)
+	input.Director = app.readString(qs, "director", "")
+	input.Actor = app.readString(qs, "actor", "")
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = app.Config.cursor.key
+	input.Filters.CursorScope = input.MovieFilter.CursorScope()
+
+
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "relevance", "average_rating", "rating_count",
+		"-id", "-title", "-year", "-runtime", "-relevance", "-average_rating", "-rating_count"}
+
+	v.Check(input.Search != "" || (input.Fuzzy && input.Title != "") || !strings.Contains(input.Filters.Sort, "relevance"), "sort", "relevance sort requires a q search term or a fuzzy title")
 
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
 
+	data.ValidateMovieFilter(v, input.MovieFilter)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid(){
 		app.failedValidationResponse(w,r,v.Errors)
@@ -209,14 +324,77 @@ func (app *application) listMovieHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 
-	movies, metadata ,err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata ,err := app.models.Movies.GetAll(r.Context(), input.MovieFilter, input.Filters)
 	if err != nil {
 		app.serverStatusError(w,r,err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	headers, err := movieListHeaders(movies, metadata)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, headers)
 	if err != nil {
 		app.serverStatusError(w,r, err)
 	}
 }
+
+
+func (app *application) autocompleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	prefix := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	if data.ValidateAutocomplete(v, prefix, limit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Autocomplete(r.Context(), prefix, limit)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+
+func (app *application) listMovieCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	credits, err := app.models.People.GetCreditsForMovie(r.Context(), id)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"credits": credits}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/jsonpatch"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+// acceptedPatchTypes are the request bodies PATCH /v1/movies/:id understands.
+const acceptedPatchTypes = "application/json, application/merge-patch+json, application/json-patch+json"
+
+// movieDocument is the part of a movie a PATCH request can change, as the
+// JSON document that merge patches and JSON patches are applied to.
+type movieDocument struct {
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Year        int32        `json:"year"`
+	Runtime     data.Runtime `json:"runtime"`
+	Genres      []string     `json:"genres"`
+}
+
+// applyMoviePatch applies the request body to movie according to its
+// Content-Type: a plain JSON object of the fields to set, an RFC 7396 merge
+// patch or an RFC 6902 JSON patch. It writes the error response itself and
+// returns false when the body can't be applied.
+func (app *application) applyMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	mediaType := "application/json"
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType = contentType
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		return app.applyMovieFields(w, r, movie)
+
+	case "application/merge-patch+json":
+		var patch interface{}
+
+		err := app.readJSON(w, r, &patch)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return false
+		}
+
+		return app.applyMovieDocumentPatch(w, r, movie, func(doc interface{}) (interface{}, error) {
+			return jsonpatch.MergePatch(doc, patch), nil
+		})
+
+	case "application/json-patch+json":
+		var operations []jsonpatch.Operation
+
+		err := app.readJSON(w, r, &operations)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return false
+		}
+
+		return app.applyMovieDocumentPatch(w, r, movie, func(doc interface{}) (interface{}, error) {
+			return jsonpatch.Apply(doc, operations)
+		})
+
+	default:
+		w.Header().Set("Accept-Patch", acceptedPatchTypes)
+		app.unsupportedMediaTypeResponse(w, r, acceptedPatchTypes)
+		return false
+	}
+}
+
+// applyMovieFields sets the fields present in a plain JSON body. A field that
+// is missing or null is left as it is.
+func (app *application) applyMovieFields(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	var input struct {
+		Title       *string       `json:"title"`
+		Description *string       `json:"description"`
+		Year        *int32        `json:"year"`
+		Runtime     *data.Runtime `json:"runtime"`
+		Genres      []string      `json:"genres"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return false
+	}
+
+	if input.Title != nil {
+		movie.Title = *input.Title
+	}
+	if input.Description != nil {
+		movie.Description = *input.Description
+	}
+	if input.Year != nil {
+		movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		movie.Runtime = *input.Runtime
+	}
+	if input.Genres != nil {
+		movie.Genres = input.Genres
+	}
+
+	return true
+}
+
+// applyMovieDocumentPatch runs patch over the movie's document form and reads
+// the result back into movie. Members the patch removes come back as zero
+// values, which ValidateMovie then reports if they are required.
+func (app *application) applyMovieDocumentPatch(w http.ResponseWriter, r *http.Request, movie *data.Movie,
+	patch func(doc interface{}) (interface{}, error)) bool {
+	js, err := json.Marshal(movieDocument{
+		Title:       movie.Title,
+		Description: movie.Description,
+		Year:        movie.Year,
+		Runtime:     movie.Runtime,
+		Genres:      movie.Genres,
+	})
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return false
+	}
+
+	var doc interface{}
+
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+
+	doc, err = patch(doc)
+	if err != nil {
+		v.AddErrors("patch", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	js, err = json.Marshal(doc)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return false
+	}
+
+	var result movieDocument
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&result)
+	if err != nil {
+		v.AddErrors("patch", fmt.Sprintf("does not produce a valid movie: %s", strings.TrimPrefix(err.Error(), "json: ")))
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	movie.Title = result.Title
+	movie.Description = result.Description
+	movie.Year = result.Year
+	movie.Runtime = result.Runtime
+	movie.Genres = result.Genres
+
+	return true
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+// readMovieRevision loads the revision named in the URL. It writes the error
+// response itself and returns nil when the revision can't be found.
+func (app *application) readMovieRevision(w http.ResponseWriter, r *http.Request) *data.MovieRevision {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	version, err := app.readNamedIDParam(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	revision, err := app.models.Movies.GetRevision(r.Context(), movieID, int32(version))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return nil
+	}
+
+	return revision
+}
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafelist = []string{"version", "created_at", "-version", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.GetRevisions(r.Context(), id, input.Filters)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	// Revisions outlive a trashed or purged movie, and are listed just as
+	// showMovieRevisionHandler serves them. Only a movie with no history at
+	// all is not found; a page past the end is simply empty.
+	if len(revisions) == 0 && input.Filters.Page == 1 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	revision := app.readMovieRevision(w, r)
+	if revision == nil {
+		return
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+// revertMovieHandler puts a movie back the way it was at an earlier revision.
+// The revert is saved as an ordinary update, so it is subject to the same
+// version check and is itself recorded as a new revision.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	revision := app.readMovieRevision(w, r)
+	if revision == nil {
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), revision.MovieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkMovieIfMatch(w, r, movie) {
+		return
+	}
+
+	err = revision.ApplyTo(movie)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Update(app.contextWithActor(r), movie)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrorEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	headers, err := movieHeaders(movie)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
@@ -1,28 +1,131 @@
 package main
 
 import (
-	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	router.NotFound = app.routeLabel("unmatched", app.notFoundResponse)
+
+	router.MethodNotAllowed = app.routeLabel("unmatched", app.methodNotAllowed)
+
+	// handle registers a route along with its pattern, which labels the
+	// request metrics.
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.routeLabel(pattern, handler))
+	}
+
+	handle(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+	handle(http.MethodGet, "/debug/metrics", app.requirePermission("metrics:read", app.metricsHandler))
+
+	handle(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMovieHandler))
+	handle(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	handle(http.MethodGet, "/v1/movies/:id", app.dispatchStatic("id", map[string]http.HandlerFunc{
+		"autocomplete": app.requirePermission("movies:read", app.autocompleteMovieHandler),
+		"trash":        app.requirePermission("movies:write", app.listTrashHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	handle(http.MethodPost, "/v1/movies/:id", app.dispatchStatic("id", map[string]http.HandlerFunc{
+		"batch": app.requirePermission("movies:write", app.maxBodySize(app.Config.batch.maxBodyBytes, app.idempotent(app.batchMoviesHandler))),
+	}, app.methodNotAllowedFor(router)))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHendler))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	handle(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+
+	handle(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	handle(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	handle(http.MethodPost, "/v1/movies/:id/revisions/:version/revert", app.requirePermission("movies:write", app.revertMovieHandler))
+
+	handle(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listMovieCreditsHandler))
+
+	handle(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listMovieReviewsHandler))
+	handle(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createMovieReviewHandler))
+	handle(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showMovieReviewHandler))
+	handle(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.updateMovieReviewHandler))
+	handle(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.deleteMovieReviewHandler))
+
+	handle(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	handle(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	handle(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	handle(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	handle(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+	handle(http.MethodGet, "/v1/people/:id/filmography", app.requirePermission("movies:read", app.showFilmographyHandler))
+
+	handle(http.MethodGet, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.listWatchlistHandler))
+	handle(http.MethodPost, "/v1/users/me/watchlist", app.requirePermission("movies:read", app.addToWatchlistHandler))
+	handle(http.MethodDelete, "/v1/users/me/watchlist/:movie_id", app.requirePermission("movies:read", app.removeFromWatchlistHandler))
+	handle(http.MethodGet, "/v1/users/me/collections", app.requirePermission("movies:read", app.listUserCollectionsHandler))
+
+	handle(http.MethodPost, "/v1/collections", app.requirePermission("movies:read", app.createCollectionHandler))
+	handle(http.MethodGet, "/v1/collections/:id", app.requirePermission("movies:read", app.showCollectionHandler))
+	handle(http.MethodPatch, "/v1/collections/:id", app.requirePermission("movies:read", app.updateCollectionHandler))
+	handle(http.MethodDelete, "/v1/collections/:id", app.requirePermission("movies:read", app.deleteCollectionHandler))
+	handle(http.MethodPost, "/v1/collections/:id/share", app.requirePermission("movies:read", app.rotateCollectionShareTokenHandler))
+	handle(http.MethodPut, "/v1/collections/:id/order", app.requirePermission("movies:read", app.reorderCollectionHandler))
+	handle(http.MethodGet, "/v1/collections/:id/movies", app.requirePermission("movies:read", app.listCollectionMoviesHandler))
+	handle(http.MethodPost, "/v1/collections/:id/movies", app.requirePermission("movies:read", app.addCollectionMovieHandler))
+	handle(http.MethodDelete, "/v1/collections/:id/movies/:movie_id", app.requirePermission("movies:read", app.removeCollectionMovieHandler))
+	handle(http.MethodGet, "/v1/shared/collections/:token", app.showSharedCollectionHandler)
+
+	handle(http.MethodPost, "/v1/users", app.idempotent(app.registrUserHendler))
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHendler)
+	handle(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	handle(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	return app.requestID(app.recordMetrics(app.logRequest(app.recoverPanic(app.enableCORS(app.reteLimit(app.authenticate(router)))))))
+}
+
+// dispatchStatic lets a fixed path such as /v1/movies/autocomplete live
+// beside /v1/movies/:id. httprouter refuses to register a static segment
+// next to a wildcard in the same position, so the wildcard route is
+// registered alone and this sends the reserved names to their own handlers.
+// Their requests are labelled with the name in place of the parameter.
+func (app *application) dispatchStatic(param string, static map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		value := httprouter.ParamsFromContext(r.Context()).ByName(param)
+
+		if handler, ok := static[value]; ok {
+			info := app.contextGetRequestInfo(r)
+			info.route = strings.Replace(info.route, ":"+param, value, 1)
+
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
 
-	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
+// methodNotAllowedFor answers a method that is only registered on a path for
+// the sake of its dispatchStatic names. It lists the methods the path does
+// support in Allow, as httprouter does when a method isn't registered at all.
+func (app *application) methodNotAllowedFor(router *httprouter.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := []string{http.MethodOptions}
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if method == r.Method {
+				continue
+			}
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMovieHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHendler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+			if handle, _, _ := router.Lookup(method, r.URL.Path); handle != nil {
+				allowed = append(allowed, method)
+			}
+		}
 
+		sort.Strings(allowed)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registrUserHendler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHendler)
+		app.contextGetRequestInfo(r).route = "unmatched"
 
-	return app.recoverPanic(app.reteLimit(router))
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		app.methodNotAllowed(w, r)
+	}
 }
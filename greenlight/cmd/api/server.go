@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"greenlight.rasulabduvaitov.net/internal/jsonlog"
 )
 
 
@@ -19,11 +22,16 @@ func (app *application) server() error {
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		ErrorLog:     slog.NewLogLogger(jsonlog.NewSlogHandler(app.logger), slog.LevelError),
 	}
 
 
 	shutDownError := make(chan error)
 
+	stopJobs := make(chan struct{})
+	trashDone := app.purgeTrash(stopJobs)
+	keysDone := app.expireIdempotencyKeys(stopJobs)
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 
@@ -32,7 +40,7 @@ func (app *application) server() error {
 		s := <-quit
 
 
-		app.logger.PrintInfo("shutdown server", map[string]string{
+		app.logger.PrintInfo("shutdown server", map[string]interface{}{
 			"signal": s.String(),
 		})
 
@@ -44,17 +52,20 @@ func (app *application) server() error {
 			shutDownError <- err
 		}
 		
-		app.logger.PrintInfo("Complating background tasks", map[string]string{
+		app.logger.PrintInfo("Complating background tasks", map[string]interface{}{
 			"adr": srv.Addr,
 		})
 
+		close(stopJobs)
+		<-trashDone
+		<-keysDone
 
 		app.wg.Wait()
 		shutDownError <- nil
 		
 	}()
 
-	app.logger.PrintInfo("starting server", map[string]string{
+	app.logger.PrintInfo("starting server", map[string]interface{}{
 		"addr": srv.Addr,
 		"env": app.Config.environment,
 		})
@@ -69,7 +80,7 @@ func (app *application) server() error {
 		return err
 	}
 
-	app.logger.PrintInfo("stopped server", map[string]string{
+	app.logger.PrintInfo("stopped server", map[string]interface{}{
 		"addr": srv.Addr,
 	})
 
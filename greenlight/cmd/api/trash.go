@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.rasulabduvaitov.net/internal/data"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetTrash(r.Context(), input.Filters)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Restore(app.contextWithActor(r), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.models.Movies.Get(r.Context(), id)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	headers, err := movieHeaders(movie)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"movie": movie}, headers)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
+
+// purgeTrash permanently deletes movies that have been in the trash for
+// longer than the retention period, checking every purge interval until stop
+// is closed. Each run goes through app.background, so shutdown waits for a
+// purge that is under way. The returned channel is closed once no more runs
+// will be started.
+func (app *application) purgeTrash(stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+
+	if app.Config.trash.retention <= 0 || app.Config.trash.purgeInterval <= 0 {
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(app.Config.trash.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				app.background(nil, func() {
+					cutoff := time.Now().Add(-app.Config.trash.retention)
+
+					purged, err := app.models.Movies.Purge(context.Background(), cutoff)
+					if err != nil {
+						app.logger.PrintError(err, map[string]interface{}{
+							"job":   "purge_trash",
+							"count": purged,
+						})
+						return
+					}
+
+					if purged > 0 {
+						app.logger.PrintInfo("purged movies from trash", map[string]interface{}{
+							"count":  purged,
+							"cutoff": cutoff.UTC().Format(time.RFC3339),
+						})
+					}
+				})
+			}
+		}
+	}()
+
+	return done
+}
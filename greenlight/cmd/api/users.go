@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"net/http"
 	"time"
 
@@ -44,7 +43,7 @@ func (app *application) registrUserHendler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch{
 		case errors.Is(err, data.ErrorDublicateEmail):
@@ -56,13 +55,19 @@ func (app *application) registrUserHendler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActiation)
+	err = app.models.Permissions.AddForUser(r.Context(), user.ID, "movies:read")
 	if err != nil {
 		app.serverStatusError(w,r,err)
 		return
 	}
 
-	app.background(func(){
+	token, err := app.models.Token.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActiation)
+	if err != nil {
+		app.serverStatusError(w,r,err)
+		return
+	}
+
+	app.background(r, func(){
 
 
 		data := map[string]interface{}{
@@ -70,15 +75,15 @@ func (app *application) registrUserHendler(w http.ResponseWriter, r *http.Reques
 			"userID": user.ID,
 		}
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
-			app.logger.PrintError(err, nil)
+			app.logError(r, err)
 		}
 		
 	})
 		
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"users": user}, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"users": user}, nil)
 	if err != nil {
 		app.serverStatusError(w,r,err)
 	}
@@ -106,10 +111,8 @@ func (app *application) activateUserHendler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActiation, input.TokenPlainText)
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeActiation, input.TokenPlainText)
 	if err != nil {
-		fmt.Println("user:", user)
-		fmt.Println("error:", err)
 		switch{
 		case errors.Is(err, data.ErrorRecordNotFound):
 			v.AddErrors("token", "invalid or expired token")
@@ -123,7 +126,7 @@ func (app *application) activateUserHendler(w http.ResponseWriter, r *http.Reque
 
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
 		switch{
 		case errors.Is(err, data.ErrorEditConflict):
@@ -135,16 +138,82 @@ func (app *application) activateUserHendler(w http.ResponseWriter, r *http.Reque
 	}
 
 
-	err = app.models.Token.DeleteAllForUser(user.ID, data.ScopeActiation)
+	err = app.models.Token.DeleteAllForUser(r.Context(), user.ID, data.ScopeActiation)
 	if err != nil {
 		app.serverStatusError(w,r,err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK ,envelope{"user": user}, nil)
+	err = app.writeJSON(w, r, http.StatusOK ,envelope{"user": user}, nil)
 	if err != nil {
 		app.serverStatusError(w,r, err)
 	}
 
 
-}
\ No newline at end of file
+}
+
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlainText string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidetePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlainText(v, input.TokenPlainText)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopePasswordReset, input.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorRecordNotFound):
+			v.AddErrors("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrorEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverStatusError(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Token.DeleteAllScopesForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+		return
+	}
+
+	env := envelope{"message": "your password was successfully reset"}
+
+	err = app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverStatusError(w, r, err)
+	}
+}
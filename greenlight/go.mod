@@ -1,6 +1,6 @@
 module greenlight.rasulabduvaitov.net
 
-go 1.20
+go 1.21
 
 require github.com/julienschmidt/httprouter v1.3.0
 
@@ -8,9 +8,12 @@ require github.com/lib/pq v1.10.0
 
 require golang.org/x/time v0.3.0
 
-require golang.org/x/crypto v0.13.0
+require (
+	github.com/go-mail/mail/v2 v2.3.0
+	golang.org/x/crypto v0.13.0
+)
 
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	gopkg.in/mail.v2 v2.3.1 // indirect
 )
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrorBatchAborted = errors.New("batch aborted")
+
+// MovieBatchOperation is one write in a batch. Action is RevisionCreate,
+// RevisionUpdate or RevisionDelete; Movie is the movie to insert, the edited
+// movie to update, or for a delete carries the ID and, if the delete is
+// conditional, the version as Delete takes it.
+type MovieBatchOperation struct {
+	Action string
+	Movie  *Movie
+}
+
+// ApplyBatch runs the operations in order and returns an error for each, nil
+// where it succeeded. When atomic is set they share one transaction and the
+// first failure rolls the whole batch back, leaving every other operation
+// with ErrorBatchAborted. Otherwise each operation is committed on its own,
+// just as Insert, Update and Delete would. The error returned alongside is
+// for the batch as a whole, such as a failed commit.
+func (m MovieModel) ApplyBatch(ctx context.Context, operations []*MovieBatchOperation, atomic bool) ([]error, error) {
+	errs := make([]error, len(operations))
+
+	if !atomic {
+		for i, operation := range operations {
+			switch operation.Action {
+			case RevisionCreate:
+				errs[i] = m.Insert(ctx, operation.Movie)
+			case RevisionUpdate:
+				errs[i] = m.Update(ctx, operation.Movie)
+			case RevisionDelete:
+				errs[i] = m.Delete(ctx, operation.Movie.ID, operation.Movie.Version)
+			default:
+				errs[i] = fmt.Errorf("unknown batch action %q", operation.Action)
+			}
+		}
+
+		return errs, nil
+	}
+
+	// Each statement gets the usual timeout, so the transaction itself is
+	// only bound to ctx; a large batch may well outlast a single timeout.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, operation := range operations {
+		err := func() error {
+			ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+			defer cancel()
+
+			switch operation.Action {
+			case RevisionCreate:
+				return insertMovie(ctx, tx, operation.Movie)
+			case RevisionUpdate:
+				return updateMovie(ctx, tx, operation.Movie)
+			case RevisionDelete:
+				if operation.Movie.ID < 1 {
+					return ErrorRecordNotFound
+				}
+				return setMovieDeleted(ctx, tx, operation.Movie.ID, operation.Movie.Version, deleteMovieQuery, RevisionDelete)
+			default:
+				return fmt.Errorf("unknown batch action %q", operation.Action)
+			}
+		}()
+		if err != nil {
+			abortBatch(errs, i, err)
+			return errs, nil
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+// abortBatch records err against the operation that failed and marks every
+// other operation in an atomic batch as aborted.
+func abortBatch(errs []error, failed int, err error) {
+	for i := range errs {
+		errs[i] = ErrorBatchAborted
+	}
+
+	errs[failed] = err
+}
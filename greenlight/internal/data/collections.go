@@ -0,0 +1,479 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+var (
+	ErrorInvalidOrder = errors.New("invalid order")
+)
+
+// Collection is a user's named, ordered list of movies. Public collections
+// can be read by any user; private ones only by their owner or through the
+// share link.
+type Collection struct {
+	ID          int64     `json:"id"`
+	UserID      int64     `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Public      bool      `json:"public"`
+	ShareToken  string    `json:"share_token,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 200, "name", "must not be more than 200 bytes long")
+	v.Check(len(collection.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+}
+
+func ValidateShareToken(v *validator.Validator, token string) {
+	ValidateTokenPlainText(v, token)
+}
+
+func generateShareToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+type CollectionModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m CollectionModel) Insert(ctx context.Context, collection *Collection) error {
+	shareToken, err := generateShareToken()
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO collections (user_id, name, description, public, share_token)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, version`
+
+	args := []interface{}{collection.UserID, collection.Name, collection.Description, collection.Public, shareToken}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+	if err != nil {
+		return err
+	}
+
+	collection.ShareToken = shareToken
+
+	return nil
+}
+
+func (m CollectionModel) get(ctx context.Context, column string, value interface{}) (*Collection, error) {
+	query := fmt.Sprintf(`
+	SELECT id, user_id, name, description, public, share_token, created_at, version
+	FROM collections
+	WHERE %s = $1`, column)
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, value).Scan(
+		&collection.ID,
+		&collection.UserID,
+		&collection.Name,
+		&collection.Description,
+		&collection.Public,
+		&collection.ShareToken,
+		&collection.CreatedAt,
+		&collection.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+func (m CollectionModel) Get(ctx context.Context, id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrorRecordNotFound
+	}
+
+	return m.get(ctx, "id", id)
+}
+
+func (m CollectionModel) GetByShareToken(ctx context.Context, shareToken string) (*Collection, error) {
+	return m.get(ctx, "share_token", shareToken)
+}
+
+func (m CollectionModel) Update(ctx context.Context, collection *Collection) error {
+	query := `
+	UPDATE collections
+	SET name = $1, description = $2, public = $3, share_token = $4, version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version`
+
+	args := []interface{}{
+		collection.Name,
+		collection.Description,
+		collection.Public,
+		collection.ShareToken,
+		collection.ID,
+		collection.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RotateShareToken replaces the collection's share token, so links handed
+// out before stop working. It goes through Update, so the usual version check
+// applies.
+func (m CollectionModel) RotateShareToken(ctx context.Context, collection *Collection) error {
+	shareToken, err := generateShareToken()
+	if err != nil {
+		return err
+	}
+
+	previous := collection.ShareToken
+	collection.ShareToken = shareToken
+
+	err = m.Update(ctx, collection)
+	if err != nil {
+		collection.ShareToken = previous
+	}
+
+	return err
+}
+
+func (m CollectionModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrorRecordNotFound
+	}
+
+	query := `
+	DELETE FROM collections
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrorRecordNotFound
+	}
+
+	return nil
+}
+
+func (m CollectionModel) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, user_id, name, description, public, share_token, created_at, version
+	FROM collections
+	WHERE user_id = $1
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.UserID,
+			&collection.Name,
+			&collection.Description,
+			&collection.Public,
+			&collection.ShareToken,
+			&collection.CreatedAt,
+			&collection.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return collections, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// AddMovie appends a movie to the end of the collection. Adding a movie that
+// is already in it leaves it where it is. Movies in the trash can't be added.
+func (m CollectionModel) AddMovie(ctx context.Context, collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	WITH movie AS (
+		SELECT id FROM movies WHERE id = $2 AND deleted_at IS NULL
+	), inserted AS (
+		INSERT INTO collection_items (collection_id, movie_id, position)
+		SELECT $1, movie.id, (SELECT COALESCE(max(position), 0) + 1 FROM collection_items WHERE collection_id = $1)
+		FROM movie
+		ON CONFLICT DO NOTHING
+	)
+	SELECT EXISTS (SELECT 1 FROM movie)`
+
+	var found bool
+
+	err = tx.QueryRowContext(ctx, query, collectionID, movieID).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return ErrorRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// lockCollection locks the collection's row for the rest of the transaction,
+// so that changes to its item positions are made one at a time.
+func lockCollection(ctx context.Context, tx *sql.Tx, collectionID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `
+	SELECT id
+	FROM collections
+	WHERE id = $1
+	FOR UPDATE`, collectionID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveMovie takes a movie out of the collection and closes the gap it
+// leaves in the positions.
+func (m CollectionModel) RemoveMovie(ctx context.Context, collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var position int32
+
+	err = tx.QueryRowContext(ctx, `
+	DELETE FROM collection_items
+	WHERE collection_id = $1 AND movie_id = $2
+	RETURNING position`, collectionID, movieID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE collection_items
+	SET position = position - 1
+	WHERE collection_id = $1 AND position > $2`, collectionID, position)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Reorder sets the order of the collection's movies. movieIDs must list every
+// movie in the collection exactly once, or ErrorInvalidOrder is returned.
+// Movies in the trash aren't listed by GetMovies, so they aren't expected here
+// either; they keep their relative order after the others, ready for when they
+// are restored.
+func (m CollectionModel) Reorder(ctx context.Context, collectionID int64, movieIDs []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, `
+	SELECT count(*)
+	FROM collection_items
+	JOIN movies ON movies.id = collection_items.movie_id
+	WHERE collection_id = $1 AND movies.deleted_at IS NULL`, collectionID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count != len(movieIDs) {
+		return ErrorInvalidOrder
+	}
+
+	result, err := tx.ExecContext(ctx, `
+	UPDATE collection_items
+	SET position = o.position
+	FROM unnest($2::bigint[]) WITH ORDINALITY AS o(movie_id, position), movies
+	WHERE collection_items.collection_id = $1 AND collection_items.movie_id = o.movie_id
+		AND movies.id = o.movie_id AND movies.deleted_at IS NULL`,
+		collectionID, pq.Array(movieIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if int(rowsAffected) != count {
+		return ErrorInvalidOrder
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE collection_items
+	SET position = $2 + t.rank
+	FROM (
+		SELECT movie_id, row_number() OVER (ORDER BY position) AS rank
+		FROM collection_items
+		JOIN movies ON movies.id = collection_items.movie_id
+		WHERE collection_id = $1 AND movies.deleted_at IS NOT NULL
+	) t
+	WHERE collection_items.collection_id = $1 AND collection_items.movie_id = t.movie_id`,
+		collectionID, count)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CollectionModel) GetMovies(ctx context.Context, collectionID int64, filters Filters) ([]*ListedMovie, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM (
+		SELECT movies.id, movies.created_at, title, description, year, runtime, genres, version,
+			average_rating, rating_count, collection_items.added_at, collection_items.position
+		FROM collection_items
+		INNER JOIN movies ON movies.id = collection_items.movie_id
+		WHERE collection_items.collection_id = $1 AND movies.deleted_at IS NULL
+	) m
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, listedMovieColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*ListedMovie{}
+
+	for rows.Next() {
+		item, err := scanListedMovie(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"fmt"
 	"math"
 	"strings"
 
@@ -12,6 +13,12 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       string
+	CursorKey    []byte
+	// CursorScope identifies the filters a listing was made with, such as
+	// MovieFilter.CursorScope. A cursor is only accepted with the scope it
+	// was issued for.
+	CursorScope string
 }
 
 
@@ -21,30 +28,142 @@ type Metadata struct {
 	FirstPage    int `json:"filter_page,omitempty"`
 	LastPage     int `json:"last_page,omitempty"`
 	TotalRecords int `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
 }
 
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+type sortField struct {
+	column string
+	desc   bool
+}
+
+// sortFields parses the comma separated Sort value, such as
+// "-year,title", into the full ordering used for a listing. Fields that aren't
+// in SortSafelist are skipped (ValidateFilters reports them), so nothing unsafe
+// ever reaches the SQL. The ordering always ends with id so that every row has
+// a unique position; fields given after id are dropped.
+func (f Filters) sortFields() []sortField {
+	var fields []sortField
+
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(f.Sort, ",") {
+		if !validator.In(part, f.SortSafelist...) {
+			continue
+		}
+
+		column := strings.TrimPrefix(part, "-")
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+
+		desc := strings.HasPrefix(part, "-")
+
+		// Relevance reads best-first, so "relevance" sorts by descending rank
+		// and "-relevance" by ascending.
+		if column == "relevance" {
+			desc = !desc
+		}
+
+		fields = append(fields, sortField{column: column, desc: desc})
+
+		// id is unique, so any field after it could never decide the order.
+		// Stopping here also keeps id last, where cursors expect it.
+		if column == "id" {
+			return fields
 		}
 	}
-	panic("unsafe sort parameter:" + f.Sort)
+
+	return append(fields, sortField{column: "id"})
 }
 
-func (f Filters) sortDiraction() string {
-	if strings.HasPrefix(f.Sort, "-") {
-		return "DESC"
+// orderBy builds the ORDER BY clause for the listing. reverse flips every
+// direction, which is how the page before a cursor is fetched.
+func (f Filters) orderBy(reverse bool) string {
+	var parts []string
+
+	for _, field := range f.sortFields() {
+		dir := "ASC"
+		if field.desc != reverse {
+			dir = "DESC"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", field.column, dir))
 	}
-	return "ASC"
+
+	return strings.Join(parts, ", ")
 }
 
-func (f Filters) limit() int {
-	return f.PageSize
+// cursor decodes the cursor parameter, returning nil when the listing is in
+// page-number mode.
+func (f Filters) cursor() (*Cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	c, err := DecodeCursor(f.CursorKey, f.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Sort != f.Sort || c.Scope != f.CursorScope || len(c.Values) != len(f.sortFields())-1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return c, nil
 }
 
-func (f Filters) offset() int {
-	return (f.Page - 1) * f.PageSize
+// keysetCondition returns a WHERE fragment selecting the rows strictly after
+// the cursor (or before it, for backward cursors) in the listing's order.
+// Placeholders are numbered from firstArg.
+func (f Filters) keysetCondition(c *Cursor, firstArg int) (string, []interface{}) {
+	fields := f.sortFields()
+	values := append(append([]interface{}{}, c.Values...), c.ID)
+
+	var (
+		ors  []string
+		args []interface{}
+	)
+
+	for i := range fields {
+		var ands []string
+
+		for j := 0; j <= i; j++ {
+			op := "="
+			if j == i {
+				op = ">"
+				if fields[j].desc != c.Backward {
+					op = "<"
+				}
+			}
+
+			ands = append(ands, fmt.Sprintf("%s %s $%d", fields[j].column, op, firstArg+len(args)))
+			args = append(args, values[j])
+		}
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// newCursor builds the signed cursor for a row given its sort key values.
+func (f Filters) newCursor(values []interface{}, id int64, backward bool) (string, error) {
+	return EncodeCursor(f.CursorKey, Cursor{
+		Sort:     f.Sort,
+		Scope:    f.CursorScope,
+		Values:   values,
+		ID:       id,
+		Backward: backward,
+	})
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -53,7 +172,21 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	parts := strings.Split(f.Sort, ",")
+	columns := make([]string, 0, len(parts))
+
+	for _, part := range parts {
+		v.Check(validator.In(part, f.SortSafelist...), "sort", "invalid sort value")
+		columns = append(columns, strings.TrimPrefix(part, "-"))
+	}
+
+	v.Check(len(parts) <= 4, "sort", "must not contain more than 4 fields")
+	v.Check(validator.Unique(columns), "sort", "must not contain the same field more than once")
+
+	if f.Cursor != "" && v.Valid() {
+		_, err := f.cursor()
+		v.Check(err == nil, "cursor", "must be a cursor returned for the same filters and sort")
+	}
 }
 
 
@@ -69,4 +202,4 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 		LastPage: int(math.Ceil(float64(totalRecords) / float64(pageSize))),
 		TotalRecords: totalRecords,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+var (
+	ErrorIdempotencyKeyInUse    = errors.New("idempotency key in use")
+	ErrorIdempotencyKeyMismatch = errors.New("idempotency key mismatch")
+)
+
+// IdempotentResponse is the response to the first request made with an
+// Idempotency-Key, kept so that retries can be answered with it.
+type IdempotentResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.Check(key != "", "Idempotency-Key", "must be provided")
+	v.Check(len(key) <= 255, "Idempotency-Key", "must not be more than 255 bytes long")
+}
+
+type IdempotencyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Begin claims key for a request with the given fingerprint. It returns a nil
+// response when the caller should go ahead and handle the request, and the
+// stored response when an earlier request with the same key and fingerprint
+// has finished. ErrorIdempotencyKeyMismatch means the key was used for a
+// different request and ErrorIdempotencyKeyInUse that the first request is
+// still running. Expired keys are claimed afresh, and so is a key whose first
+// request has held it for longer than lease without finishing, since that
+// request most likely died with its process.
+func (m IdempotencyModel) Begin(ctx context.Context, owner, key string, fingerprint []byte, ttl, lease time.Duration) (*IdempotentResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+	INSERT INTO idempotency_keys (owner, key, fingerprint, locked_at, expires_at)
+	VALUES ($1, $2, $3, NOW(), $4)
+	ON CONFLICT (owner, key) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint, status = NULL, header = NULL, body = NULL,
+		created_at = NOW(), locked_at = NOW(), expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at <= NOW()
+		OR (idempotency_keys.status IS NULL AND idempotency_keys.locked_at <= $5
+			AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+	RETURNING owner`
+
+	args := []interface{}{owner, key, fingerprint, time.Now().Add(ttl), time.Now().Add(-lease)}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&owner)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	var (
+		stored []byte
+		status sql.NullInt32
+		header []byte
+		body   []byte
+	)
+
+	query = `
+	SELECT fingerprint, status, header, body
+	FROM idempotency_keys
+	WHERE owner = $1 AND key = $2`
+
+	err = m.DB.QueryRowContext(ctx, query, owner, key).Scan(&stored, &status, &header, &body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Removed by Release or DeleteExpired since the insert.
+			return nil, ErrorIdempotencyKeyInUse
+		default:
+			return nil, err
+		}
+	}
+
+	switch {
+	case !bytes.Equal(stored, fingerprint):
+		return nil, ErrorIdempotencyKeyMismatch
+	case !status.Valid:
+		return nil, ErrorIdempotencyKeyInUse
+	}
+
+	response := &IdempotentResponse{StatusCode: int(status.Int32), Body: body}
+
+	err = json.Unmarshal(header, &response.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Complete stores the response to the request that claimed key.
+func (m IdempotencyModel) Complete(ctx context.Context, owner, key string, response *IdempotentResponse) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE idempotency_keys
+	SET status = $1, header = $2, body = $3
+	WHERE owner = $4 AND key = $5 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, response.StatusCode, header, response.Body, owner, key)
+	return err
+}
+
+// Release gives up a claimed key without storing a response, so a retry is
+// handled from scratch.
+func (m IdempotencyModel) Release(ctx context.Context, owner, key string) error {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE owner = $1 AND key = $2 AND status IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, owner, key)
+	return err
+}
+
+// DeleteExpired removes keys past their TTL, returning how many went.
+func (m IdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,1809 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore holds the state shared by the in-memory models, so that
+// lookups which join tables in PostgreSQL (users and tokens, users and
+// permissions) see the same data.
+type memoryStore struct {
+	mu sync.RWMutex
+
+	movies        map[int64]*Movie
+	nextMovieID   int64
+	users         map[int64]*User
+	nextUserID    int64
+	tokens        map[string]*Token
+	permissions   map[int64]Permissions
+	permissionSet []string
+	reviews       map[int64]*Review
+	nextReviewID  int64
+	people        map[int64]*Person
+	nextPersonID  int64
+	credits       map[int64][]Credit
+	watchlists    map[int64][]memoryListEntry
+	collections   map[int64]*Collection
+	nextCollID    int64
+	collItems     map[int64][]memoryListEntry
+	revisions     map[int64][]*MovieRevision
+	idempotency   map[memoryIdempotencyKey]*memoryIdempotentRequest
+}
+
+type memoryIdempotencyKey struct {
+	owner string
+	key   string
+}
+
+// memoryIdempotentRequest is a claimed idempotency key. response is nil while
+// the first request is still running.
+type memoryIdempotentRequest struct {
+	fingerprint []byte
+	response    *IdempotentResponse
+	lockedAt    time.Time
+	expiresAt   time.Time
+}
+
+// memoryListEntry is a movie on a watchlist or in a collection. Collection
+// entries are kept in order, so an entry's position is its index plus one.
+type memoryListEntry struct {
+	movieID int64
+	addedAt time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		movies:        make(map[int64]*Movie),
+		users:         make(map[int64]*User),
+		tokens:        make(map[string]*Token),
+		permissions:   make(map[int64]Permissions),
+		permissionSet: []string{"movies:read", "movies:write", "metrics:read"},
+		reviews:       make(map[int64]*Review),
+		people:        make(map[int64]*Person),
+		credits:       make(map[int64][]Credit),
+		watchlists:    make(map[int64][]memoryListEntry),
+		collections:   make(map[int64]*Collection),
+		collItems:     make(map[int64][]memoryListEntry),
+		revisions:     make(map[int64][]*MovieRevision),
+		idempotency:   make(map[memoryIdempotencyKey]*memoryIdempotentRequest),
+	}
+}
+
+func copyMovie(movie *Movie) *Movie {
+	c := *movie
+	if movie.Genres != nil {
+		c.Genres = append([]string{}, movie.Genres...)
+	}
+	c.Credits = nil
+	return &c
+}
+
+// credited reports whether the movie credits someone called name in role,
+// ignoring case. An empty name matches every movie. The caller must hold the
+// lock.
+func (s *memoryStore) credited(movieID int64, role, name string) bool {
+	if name == "" {
+		return true
+	}
+
+	for _, credit := range s.credits[movieID] {
+		person, ok := s.people[credit.PersonID]
+		if ok && credit.Role == role && strings.EqualFold(person.Name, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func copyUser(user *User) *User {
+	c := *user
+	return &c
+}
+
+// simpleLexemes splits s the same way to_tsvector('simple', ...) does for
+// plain words: lowercased runs of letters and digits.
+func simpleLexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func matchesTitle(movieTitle, query string) bool {
+	words := simpleLexemes(query)
+	if len(words) == 0 {
+		return true
+	}
+
+	titleWords := simpleLexemes(movieTitle)
+
+	for _, word := range words {
+		found := false
+		for _, titleWord := range titleWords {
+			if word == titleWord {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsAll(values, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range values {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func compareValues(a, b interface{}) int {
+	if as, ok := a.(string); ok {
+		bs, _ := b.(string)
+		return strings.Compare(as, bs)
+	}
+
+	af, bf := toFloat(a), toFloat(b)
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	case int32:
+		return float64(n)
+	case int:
+		return float64(n)
+	}
+	return 0
+}
+
+// compareMovieKeys orders two movies by the listing's sort fields, honouring
+// each field's direction. reverse flips the whole ordering.
+func compareMovieKeys(a, b *Movie, fields []sortField, reverse bool) int {
+	for _, field := range fields {
+		c := compareValues(movieSortValue(a, field.column), movieSortValue(b, field.column))
+		if field.desc != reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// afterCursor reports whether movie comes strictly after the cursor position
+// in the direction the cursor pages.
+func afterCursor(movie *Movie, fields []sortField, c *Cursor) bool {
+	values := append(append([]interface{}{}, c.Values...), c.ID)
+
+	for i, field := range fields {
+		cmp := compareValues(movieSortValue(movie, field.column), values[i])
+		if field.desc != c.Backward {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp > 0
+		}
+	}
+	return false
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, c := range candidates {
+		for _, v := range values {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matches applies the filter to a single movie the same way conditions does
+// in SQL.
+func (f MovieFilter) matches(movie *Movie) bool {
+	switch {
+	case f.Fuzzy && f.Title != "" && wordSimilarity(f.Title, movie.Title) < wordSimilarityThreshold:
+		return false
+	case !f.Fuzzy && !matchesTitle(movie.Title, f.Title):
+		return false
+	case f.Search != "" && searchRank(movie, f.Search) == 0:
+		return false
+	case !containsAll(movie.Genres, f.Genres):
+		return false
+	case len(f.AnyGenres) > 0 && !containsAny(movie.Genres, f.AnyGenres):
+		return false
+	case containsAny(movie.Genres, f.ExcludeGenres):
+		return false
+	case f.YearMin != 0 && int(movie.Year) < f.YearMin:
+		return false
+	case f.YearMax != 0 && int(movie.Year) > f.YearMax:
+		return false
+	case f.RuntimeMin != 0 && int(movie.Runtime) < f.RuntimeMin:
+		return false
+	case f.RuntimeMax != 0 && int(movie.Runtime) > f.RuntimeMax:
+		return false
+	case !f.CreatedAfter.IsZero() && !movie.CreatedAt.After(f.CreatedAfter):
+		return false
+	}
+	return true
+}
+
+// wordSimilarityThreshold matches pg_trgm.word_similarity_threshold's default.
+const wordSimilarityThreshold = 0.6
+
+// trigrams returns the set of trigrams pg_trgm would extract from s: each
+// lowercased word padded with two spaces in front and one behind.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+
+	for _, word := range simpleLexemes(s) {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+
+	return set
+}
+
+// wordSimilarity approximates pg_trgm's word_similarity(needle, haystack) as
+// the share of the needle's trigrams found anywhere in the haystack.
+func wordSimilarity(needle, haystack string) float64 {
+	needleTrigrams := trigrams(needle)
+	if len(needleTrigrams) == 0 {
+		return 0
+	}
+
+	haystackTrigrams := trigrams(haystack)
+
+	shared := 0
+	for t := range needleTrigrams {
+		if haystackTrigrams[t] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(needleTrigrams))
+}
+
+// searchRank approximates ts_rank_cd over the weighted title and description
+// vector: each search word found in the title scores 1, in the description
+// 0.4. There's no stemming, whatever the requested language.
+func searchRank(movie *Movie, search string) float64 {
+	titleWords := simpleLexemes(movie.Title)
+	descriptionWords := simpleLexemes(movie.Description)
+
+	rank := 0.0
+
+	for _, word := range simpleLexemes(search) {
+		found := false
+
+		if containsAll(titleWords, []string{word}) {
+			rank += 1
+			found = true
+		}
+		if containsAll(descriptionWords, []string{word}) {
+			rank += 0.4
+			found = true
+		}
+
+		if !found {
+			return 0
+		}
+	}
+
+	return rank
+}
+
+// headline wraps every word of text that matches a search word in <b></b>,
+// escaping the rest of text as HTML.
+func headline(text, search string) string {
+	words := simpleLexemes(search)
+
+	var b strings.Builder
+	start := -1
+
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word := text[start:end]
+		if containsAll(words, []string{strings.ToLower(word)}) {
+			b.WriteString("<b>" + word + "</b>")
+		} else {
+			b.WriteString(word)
+		}
+		start = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	flush(len(text))
+
+	return b.String()
+}
+
+type MemoryMovieModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return m.store.insertMovie(ctx, movie)
+}
+
+// insertMovie, updateMovie and deleteMovie do the work of the movie model's
+// Insert, Update and Delete, so that ApplyBatch can run several under one
+// lock. The caller must hold the write lock.
+func (s *memoryStore) insertMovie(ctx context.Context, movie *Movie) error {
+	for _, credit := range movie.Credits {
+		if _, ok := s.people[credit.PersonID]; !ok {
+			return ErrorUnknownPerson
+		}
+	}
+
+	revision, err := newMovieRevision(ctx, s.nextMovieID+1, 1, RevisionCreate, nil, stateOf(movie))
+	if err != nil {
+		return err
+	}
+
+	s.nextMovieID++
+
+	movie.ID = s.nextMovieID
+	movie.CreatedAt = time.Now().Truncate(time.Second)
+	movie.UpdatedAt = time.Now()
+	movie.Version = 1
+
+	s.movies[movie.ID] = copyMovie(movie)
+	s.addRevision(revision)
+
+	if len(movie.Credits) > 0 {
+		s.credits[movie.ID] = append([]Credit{}, movie.Credits...)
+	}
+
+	return nil
+}
+
+func (m *MemoryMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrorRecordNotFound
+	}
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok || movie.DeletedAt != nil {
+		return nil, ErrorRecordNotFound
+	}
+
+	return copyMovie(movie), nil
+}
+
+func (m *MemoryMovieModel) Update(ctx context.Context, movie *Movie) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return m.store.updateMovie(ctx, movie)
+}
+
+func (s *memoryStore) updateMovie(ctx context.Context, movie *Movie) error {
+	stored, ok := s.movies[movie.ID]
+	if !ok || stored.Version != movie.Version || stored.DeletedAt != nil {
+		return ErrorEditConflict
+	}
+
+	revision, err := newMovieRevision(ctx, movie.ID, movie.Version+1, RevisionUpdate, stateOf(stored), stateOf(movie))
+	if err != nil {
+		return err
+	}
+
+	movie.Version++
+	movie.UpdatedAt = time.Now()
+	movie.AverageRating = stored.AverageRating
+	movie.RatingCount = stored.RatingCount
+	s.movies[movie.ID] = copyMovie(movie)
+	s.addRevision(revision)
+
+	return nil
+}
+
+func (m *MemoryMovieModel) Delete(ctx context.Context, id int64, version int32) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	return m.store.deleteMovie(ctx, id, version)
+}
+
+func (s *memoryStore) deleteMovie(ctx context.Context, id int64, version int32) error {
+	if id < 1 {
+		return ErrorRecordNotFound
+	}
+
+	movie, ok := s.movies[id]
+	switch {
+	case version != 0 && (!ok || movie.DeletedAt != nil || movie.Version != version):
+		return ErrorEditConflict
+	case !ok || movie.DeletedAt != nil:
+		return ErrorRecordNotFound
+	}
+
+	deletedAt := time.Now().Truncate(time.Second)
+
+	after := stateOf(movie)
+	after.Deleted = true
+
+	revision, err := newMovieRevision(ctx, id, movie.Version+1, RevisionDelete, stateOf(movie), after)
+	if err != nil {
+		return err
+	}
+
+	movie.DeletedAt = &deletedAt
+	movie.UpdatedAt = time.Now()
+	movie.Version++
+	s.addRevision(revision)
+
+	return nil
+}
+
+// memoryMovieSnapshot is the movie state an atomic batch can change, kept so
+// the batch can be rolled back.
+type memoryMovieSnapshot struct {
+	movies      map[int64]*Movie
+	nextMovieID int64
+	credits     map[int64][]Credit
+	revisions   map[int64][]*MovieRevision
+}
+
+// snapshotMovies copies the movie state. Credit and revision slices are only
+// ever replaced or appended to, so copying the maps is enough for them.
+// The caller must hold the lock.
+func (s *memoryStore) snapshotMovies() *memoryMovieSnapshot {
+	snapshot := &memoryMovieSnapshot{
+		movies:      make(map[int64]*Movie, len(s.movies)),
+		nextMovieID: s.nextMovieID,
+		credits:     make(map[int64][]Credit, len(s.credits)),
+		revisions:   make(map[int64][]*MovieRevision, len(s.revisions)),
+	}
+
+	for id, movie := range s.movies {
+		snapshot.movies[id] = copyMovie(movie)
+	}
+	for id, credits := range s.credits {
+		snapshot.credits[id] = credits
+	}
+	for id, revisions := range s.revisions {
+		snapshot.revisions[id] = revisions
+	}
+
+	return snapshot
+}
+
+// restoreMovies puts back a snapshot taken by snapshotMovies. The caller must
+// hold the write lock.
+func (s *memoryStore) restoreMovies(snapshot *memoryMovieSnapshot) {
+	s.movies = snapshot.movies
+	s.nextMovieID = snapshot.nextMovieID
+	s.credits = snapshot.credits
+	s.revisions = snapshot.revisions
+}
+
+func (m *MemoryMovieModel) ApplyBatch(ctx context.Context, operations []*MovieBatchOperation, atomic bool) ([]error, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var snapshot *memoryMovieSnapshot
+	if atomic {
+		snapshot = m.store.snapshotMovies()
+	}
+
+	errs := make([]error, len(operations))
+
+	for i, operation := range operations {
+		var err error
+
+		switch operation.Action {
+		case RevisionCreate:
+			err = m.store.insertMovie(ctx, operation.Movie)
+		case RevisionUpdate:
+			err = m.store.updateMovie(ctx, operation.Movie)
+		case RevisionDelete:
+			err = m.store.deleteMovie(ctx, operation.Movie.ID, operation.Movie.Version)
+		default:
+			err = fmt.Errorf("unknown batch action %q", operation.Action)
+		}
+
+		if err != nil && atomic {
+			m.store.restoreMovies(snapshot)
+			abortBatch(errs, i, err)
+			return errs, nil
+		}
+
+		errs[i] = err
+	}
+
+	return errs, nil
+}
+
+func (m *MemoryMovieModel) GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	m.store.mu.RLock()
+
+	movies := []*Movie{}
+	for _, movie := range m.store.movies {
+		if movie.DeletedAt != nil {
+			movies = append(movies, copyMovie(movie))
+		}
+	}
+
+	m.store.mu.RUnlock()
+
+	fields := filters.sortFields()
+
+	sort.Slice(movies, func(i, j int) bool {
+		return compareMovieKeys(movies[i], movies[j], fields, false) < 0
+	})
+
+	totalRecords := len(movies)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return movies[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m *MemoryMovieModel) Restore(ctx context.Context, id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	movie, ok := m.store.movies[id]
+	if !ok || movie.DeletedAt == nil {
+		return ErrorRecordNotFound
+	}
+
+	after := stateOf(movie)
+	after.Deleted = false
+
+	revision, err := newMovieRevision(ctx, id, movie.Version+1, RevisionRestore, stateOf(movie), after)
+	if err != nil {
+		return err
+	}
+
+	movie.DeletedAt = nil
+	movie.UpdatedAt = time.Now()
+	movie.Version++
+	m.store.addRevision(revision)
+
+	return nil
+}
+
+func (m *MemoryMovieModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var purged int64
+
+	for id, movie := range m.store.movies {
+		if movie.DeletedAt != nil && movie.DeletedAt.Before(cutoff) {
+			before := stateOf(movie)
+
+			revision, err := newMovieRevision(ctx, id, movie.Version+1, RevisionPurge, before, nil)
+			if err != nil {
+				return purged, err
+			}
+
+			m.store.removeMovie(id)
+			m.store.addRevision(revision)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// removeMovie deletes a movie along with everything that references it, as
+// the ON DELETE CASCADE foreign keys do in PostgreSQL. Its revisions are the
+// audit trail and stay behind. The caller must hold the write lock.
+func (s *memoryStore) removeMovie(id int64) {
+	delete(s.movies, id)
+	delete(s.credits, id)
+
+	for userID, entries := range s.watchlists {
+		s.watchlists[userID] = removeListEntry(entries, id)
+	}
+	for collectionID, entries := range s.collItems {
+		s.collItems[collectionID] = removeListEntry(entries, id)
+	}
+
+	for reviewID, review := range s.reviews {
+		if review.MovieID == id {
+			delete(s.reviews, reviewID)
+		}
+	}
+}
+
+// addRevision appends a revision to its movie's history. The caller must hold
+// the write lock.
+func (s *memoryStore) addRevision(revision *MovieRevision) {
+	revision.CreatedAt = time.Now().Truncate(time.Second)
+	s.revisions[revision.MovieID] = append(s.revisions[revision.MovieID], revision)
+}
+
+func (m *MemoryMovieModel) GetRevisions(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	m.store.mu.RLock()
+
+	revisions := []*MovieRevision{}
+	for _, revision := range m.store.revisions[movieID] {
+		c := *revision
+		c.Snapshot = nil
+		revisions = append(revisions, &c)
+	}
+
+	m.store.mu.RUnlock()
+
+	fields := filters.sortFields()
+
+	// Versions are unique within a movie, so they stand in for the id
+	// tie-breaker.
+	sort.Slice(revisions, func(i, j int) bool {
+		for _, field := range fields {
+			c := compareValues(revisionSortValue(revisions[i], field.column), revisionSortValue(revisions[j], field.column))
+			if field.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	totalRecords := len(revisions)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return revisions[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func revisionSortValue(revision *MovieRevision, column string) interface{} {
+	switch column {
+	case "created_at":
+		return float64(revision.CreatedAt.UnixNano())
+	default:
+		return int64(revision.Version)
+	}
+}
+
+func (m *MemoryMovieModel) GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, revision := range m.store.revisions[movieID] {
+		if revision.Version == version {
+			c := *revision
+			return &c, nil
+		}
+	}
+
+	return nil, ErrorRecordNotFound
+}
+
+func (m *MemoryMovieModel) GetAll(ctx context.Context, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error) {
+	cursor, err := filters.cursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	fields := filters.sortFields()
+	reverse := cursor != nil && cursor.Backward
+
+	m.store.mu.RLock()
+
+	matched := []*Movie{}
+	for _, movie := range m.store.movies {
+		if movie.DeletedAt != nil || !filter.matches(movie) {
+			continue
+		}
+
+		if !m.store.credited(movie.ID, "director", filter.Director) || !m.store.credited(movie.ID, "actor", filter.Actor) {
+			continue
+		}
+
+		c := copyMovie(movie)
+
+		if filter.Fuzzy && filter.Title != "" {
+			c.Relevance = wordSimilarity(filter.Title, c.Title)
+		}
+
+		if filter.Search != "" {
+			c.Relevance = searchRank(c, filter.Search)
+
+			if filter.Highlight {
+				c.Highlights = &MovieHighlights{
+					Title:       headline(c.Title, filter.Search),
+					Description: headline(c.Description, filter.Search),
+				}
+			}
+		}
+
+		if cursor != nil && !afterCursor(c, fields, cursor) {
+			continue
+		}
+		matched = append(matched, c)
+	}
+
+	m.store.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return compareMovieKeys(matched[i], matched[j], fields, reverse) < 0
+	})
+
+	if cursor != nil {
+		if len(matched) > filters.limit()+1 {
+			matched = matched[:filters.limit()+1]
+		}
+		return paginateMovies(filters, cursor, matched, 0)
+	}
+
+	totalRecords := len(matched)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	movies := matched[start:end]
+	if len(movies) == 0 {
+		totalRecords = 0
+	}
+
+	return paginateMovies(filters, nil, movies, totalRecords)
+}
+
+func (m *MemoryMovieModel) Autocomplete(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error) {
+	type scored struct {
+		suggestion *MovieSuggestion
+		score      float64
+	}
+
+	m.store.mu.RLock()
+
+	var matched []scored
+	for _, movie := range m.store.movies {
+		if movie.DeletedAt != nil {
+			continue
+		}
+
+		score := wordSimilarity(prefix, movie.Title)
+		if score < wordSimilarityThreshold {
+			continue
+		}
+
+		matched = append(matched, scored{
+			suggestion: &MovieSuggestion{ID: movie.ID, Title: movie.Title, Year: movie.Year},
+			score:      score,
+		})
+	}
+
+	m.store.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].score != matched[j].score {
+			return matched[i].score > matched[j].score
+		}
+		return matched[i].suggestion.ID < matched[j].suggestion.ID
+	})
+
+	suggestions := []*MovieSuggestion{}
+	for i := 0; i < len(matched) && i < limit; i++ {
+		suggestions = append(suggestions, matched[i].suggestion)
+	}
+
+	return suggestions, nil
+}
+
+type MemoryReviewModel struct {
+	store *memoryStore
+}
+
+// refreshRating recomputes a movie's rating aggregates, as the reviews
+// trigger does in PostgreSQL. The caller must hold the write lock.
+func (m *MemoryReviewModel) refreshRating(movieID int64) {
+	movie, ok := m.store.movies[movieID]
+	if !ok {
+		return
+	}
+
+	total, count := 0, 0
+	for _, review := range m.store.reviews {
+		if review.MovieID == movieID {
+			total += int(review.Score)
+			count++
+		}
+	}
+
+	movie.AverageRating = 0
+	if count > 0 {
+		movie.AverageRating = float64(total) / float64(count)
+	}
+	movie.RatingCount = int32(count)
+	movie.UpdatedAt = time.Now()
+}
+
+func (m *MemoryReviewModel) Insert(ctx context.Context, review *Review) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if movie, ok := m.store.movies[review.MovieID]; !ok || movie.DeletedAt != nil {
+		return ErrorRecordNotFound
+	}
+
+	for _, r := range m.store.reviews {
+		if r.MovieID == review.MovieID && r.UserID == review.UserID {
+			return ErrorDuplicateReview
+		}
+	}
+
+	m.store.nextReviewID++
+
+	review.ID = m.store.nextReviewID
+	review.CreatedAt = time.Now().Truncate(time.Second)
+	review.UpdatedAt = review.CreatedAt
+	review.Version = 1
+
+	c := *review
+	m.store.reviews[review.ID] = &c
+	m.refreshRating(review.MovieID)
+
+	return nil
+}
+
+func (m *MemoryReviewModel) Get(ctx context.Context, movieID, id int64) (*Review, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	review, ok := m.store.reviews[id]
+	if !ok || review.MovieID != movieID {
+		return nil, ErrorRecordNotFound
+	}
+
+	c := *review
+	return &c, nil
+}
+
+func (m *MemoryReviewModel) Update(ctx context.Context, review *Review) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.reviews[review.ID]
+	if !ok || stored.Version != review.Version {
+		return ErrorEditConflict
+	}
+
+	review.UpdatedAt = time.Now().Truncate(time.Second)
+	review.Version++
+
+	c := *review
+	m.store.reviews[review.ID] = &c
+	m.refreshRating(review.MovieID)
+
+	return nil
+}
+
+func (m *MemoryReviewModel) Delete(ctx context.Context, movieID, id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	review, ok := m.store.reviews[id]
+	if !ok || review.MovieID != movieID {
+		return ErrorRecordNotFound
+	}
+
+	delete(m.store.reviews, id)
+	m.refreshRating(movieID)
+
+	return nil
+}
+
+func reviewSortValue(review *Review, column string) interface{} {
+	switch column {
+	case "score":
+		return int64(review.Score)
+	case "created_at":
+		return float64(review.CreatedAt.UnixNano())
+	case "updated_at":
+		return float64(review.UpdatedAt.UnixNano())
+	default:
+		return review.ID
+	}
+}
+
+func (m *MemoryReviewModel) GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	m.store.mu.RLock()
+
+	matched := []*Review{}
+	for _, review := range m.store.reviews {
+		if review.MovieID == movieID {
+			c := *review
+			matched = append(matched, &c)
+		}
+	}
+
+	m.store.mu.RUnlock()
+
+	fields := filters.sortFields()
+
+	sort.Slice(matched, func(i, j int) bool {
+		for _, field := range fields {
+			c := compareValues(reviewSortValue(matched[i], field.column), reviewSortValue(matched[j], field.column))
+			if field.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	totalRecords := len(matched)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return matched[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+type MemoryPersonModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryPersonModel) Insert(ctx context.Context, person *Person) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.nextPersonID++
+
+	person.ID = m.store.nextPersonID
+	person.CreatedAt = time.Now().Truncate(time.Second)
+	person.Version = 1
+
+	c := *person
+	m.store.people[person.ID] = &c
+
+	return nil
+}
+
+func (m *MemoryPersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	person, ok := m.store.people[id]
+	if !ok {
+		return nil, ErrorRecordNotFound
+	}
+
+	c := *person
+	return &c, nil
+}
+
+func (m *MemoryPersonModel) Update(ctx context.Context, person *Person) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.people[person.ID]
+	if !ok || stored.Version != person.Version {
+		return ErrorEditConflict
+	}
+
+	person.Version++
+
+	c := *person
+	m.store.people[person.ID] = &c
+
+	return nil
+}
+
+func (m *MemoryPersonModel) Delete(ctx context.Context, id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.people[id]; !ok {
+		return ErrorRecordNotFound
+	}
+
+	delete(m.store.people, id)
+
+	for movieID, credits := range m.store.credits {
+		kept := credits[:0]
+		for _, credit := range credits {
+			if credit.PersonID != id {
+				kept = append(kept, credit)
+			}
+		}
+		m.store.credits[movieID] = kept
+	}
+
+	return nil
+}
+
+func personSortValue(person *Person, column string) interface{} {
+	switch column {
+	case "name":
+		return person.Name
+	case "birth_year":
+		return int64(person.BirthYear)
+	default:
+		return person.ID
+	}
+}
+
+func (m *MemoryPersonModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	m.store.mu.RLock()
+
+	matched := []*Person{}
+	for _, person := range m.store.people {
+		if matchesTitle(person.Name, name) {
+			c := *person
+			matched = append(matched, &c)
+		}
+	}
+
+	m.store.mu.RUnlock()
+
+	fields := filters.sortFields()
+
+	sort.Slice(matched, func(i, j int) bool {
+		for _, field := range fields {
+			c := compareValues(personSortValue(matched[i], field.column), personSortValue(matched[j], field.column))
+			if field.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	totalRecords := len(matched)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return matched[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m *MemoryPersonModel) GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	credits := []*Credit{}
+	for _, credit := range m.store.credits[movieID] {
+		c := credit
+		if person, ok := m.store.people[credit.PersonID]; ok {
+			c.Name = person.Name
+		}
+		credits = append(credits, &c)
+	}
+
+	return credits, nil
+}
+
+func (m *MemoryPersonModel) GetFilmography(ctx context.Context, personID int64) ([]*FilmographyEntry, error) {
+	m.store.mu.RLock()
+
+	entries := []*FilmographyEntry{}
+	for movieID, credits := range m.store.credits {
+		movie, ok := m.store.movies[movieID]
+		if !ok || movie.DeletedAt != nil {
+			continue
+		}
+
+		for _, credit := range credits {
+			if credit.PersonID == personID {
+				entries = append(entries, &FilmographyEntry{
+					MovieID:   movie.ID,
+					Title:     movie.Title,
+					Year:      movie.Year,
+					Role:      credit.Role,
+					Character: credit.Character,
+				})
+			}
+		}
+	}
+
+	m.store.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Year != b.Year {
+			return a.Year > b.Year
+		}
+		if a.MovieID != b.MovieID {
+			return a.MovieID < b.MovieID
+		}
+		return a.Role < b.Role
+	})
+
+	return entries, nil
+}
+
+func removeListEntry(entries []memoryListEntry, movieID int64) []memoryListEntry {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.movieID != movieID {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+func listedSortValue(item *ListedMovie, column string) interface{} {
+	switch column {
+	case "added_at":
+		return float64(item.AddedAt.UnixNano())
+	case "position":
+		return int64(item.Position)
+	default:
+		return movieSortValue(item.Movie, column)
+	}
+}
+
+// listMovies resolves list entries to movies, then sorts and pages them the
+// way the SQL listings do. The caller must hold the read lock.
+func (s *memoryStore) listMovies(entries []memoryListEntry, positioned bool, filters Filters) ([]*ListedMovie, Metadata) {
+	items := []*ListedMovie{}
+
+	for i, entry := range entries {
+		movie, ok := s.movies[entry.movieID]
+		if !ok || movie.DeletedAt != nil {
+			continue
+		}
+
+		item := &ListedMovie{Movie: copyMovie(movie), AddedAt: entry.addedAt}
+		if positioned {
+			item.Position = int32(i + 1)
+		}
+
+		items = append(items, item)
+	}
+
+	fields := filters.sortFields()
+
+	sort.Slice(items, func(i, j int) bool {
+		for _, field := range fields {
+			c := compareValues(listedSortValue(items[i], field.column), listedSortValue(items[j], field.column))
+			if field.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	totalRecords := len(items)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return items[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+}
+
+type MemoryWatchlistModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryWatchlistModel) Add(ctx context.Context, userID, movieID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if movie, ok := m.store.movies[movieID]; !ok || movie.DeletedAt != nil {
+		return ErrorRecordNotFound
+	}
+
+	for _, entry := range m.store.watchlists[userID] {
+		if entry.movieID == movieID {
+			return nil
+		}
+	}
+
+	m.store.watchlists[userID] = append(m.store.watchlists[userID], memoryListEntry{
+		movieID: movieID,
+		addedAt: time.Now().Truncate(time.Second),
+	})
+
+	return nil
+}
+
+func (m *MemoryWatchlistModel) Remove(ctx context.Context, userID, movieID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	entries := m.store.watchlists[userID]
+	kept := removeListEntry(entries, movieID)
+	if len(kept) == len(entries) {
+		return ErrorRecordNotFound
+	}
+
+	m.store.watchlists[userID] = kept
+
+	return nil
+}
+
+func (m *MemoryWatchlistModel) GetAll(ctx context.Context, userID int64, filters Filters) ([]*ListedMovie, Metadata, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	items, metadata := m.store.listMovies(m.store.watchlists[userID], false, filters)
+
+	return items, metadata, nil
+}
+
+type MemoryCollectionModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryCollectionModel) Insert(ctx context.Context, collection *Collection) error {
+	shareToken, err := generateShareToken()
+	if err != nil {
+		return err
+	}
+
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.nextCollID++
+
+	collection.ID = m.store.nextCollID
+	collection.ShareToken = shareToken
+	collection.CreatedAt = time.Now().Truncate(time.Second)
+	collection.Version = 1
+
+	c := *collection
+	m.store.collections[collection.ID] = &c
+
+	return nil
+}
+
+func (m *MemoryCollectionModel) Get(ctx context.Context, id int64) (*Collection, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	collection, ok := m.store.collections[id]
+	if !ok {
+		return nil, ErrorRecordNotFound
+	}
+
+	c := *collection
+	return &c, nil
+}
+
+func (m *MemoryCollectionModel) GetByShareToken(ctx context.Context, shareToken string) (*Collection, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, collection := range m.store.collections {
+		if collection.ShareToken == shareToken {
+			c := *collection
+			return &c, nil
+		}
+	}
+
+	return nil, ErrorRecordNotFound
+}
+
+func (m *MemoryCollectionModel) Update(ctx context.Context, collection *Collection) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.collections[collection.ID]
+	if !ok || stored.Version != collection.Version {
+		return ErrorEditConflict
+	}
+
+	collection.Version++
+
+	c := *collection
+	m.store.collections[collection.ID] = &c
+
+	return nil
+}
+
+func (m *MemoryCollectionModel) RotateShareToken(ctx context.Context, collection *Collection) error {
+	shareToken, err := generateShareToken()
+	if err != nil {
+		return err
+	}
+
+	previous := collection.ShareToken
+	collection.ShareToken = shareToken
+
+	err = m.Update(ctx, collection)
+	if err != nil {
+		collection.ShareToken = previous
+	}
+
+	return err
+}
+
+func (m *MemoryCollectionModel) Delete(ctx context.Context, id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if _, ok := m.store.collections[id]; !ok {
+		return ErrorRecordNotFound
+	}
+
+	delete(m.store.collections, id)
+	delete(m.store.collItems, id)
+
+	return nil
+}
+
+func (m *MemoryCollectionModel) GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*Collection, Metadata, error) {
+	m.store.mu.RLock()
+
+	collections := []*Collection{}
+	for _, collection := range m.store.collections {
+		if collection.UserID == userID {
+			c := *collection
+			collections = append(collections, &c)
+		}
+	}
+
+	m.store.mu.RUnlock()
+
+	collectionSortValue := func(collection *Collection, column string) interface{} {
+		switch column {
+		case "name":
+			return collection.Name
+		case "created_at":
+			return float64(collection.CreatedAt.UnixNano())
+		default:
+			return collection.ID
+		}
+	}
+
+	fields := filters.sortFields()
+
+	sort.Slice(collections, func(i, j int) bool {
+		for _, field := range fields {
+			c := compareValues(collectionSortValue(collections[i], field.column), collectionSortValue(collections[j], field.column))
+			if field.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	totalRecords := len(collections)
+
+	start := filters.offset()
+	if start > totalRecords {
+		start = totalRecords
+	}
+	end := start + filters.limit()
+	if end > totalRecords {
+		end = totalRecords
+	}
+
+	return collections[start:end], calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m *MemoryCollectionModel) AddMovie(ctx context.Context, collectionID, movieID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if movie, ok := m.store.movies[movieID]; !ok || movie.DeletedAt != nil {
+		return ErrorRecordNotFound
+	}
+	if _, ok := m.store.collections[collectionID]; !ok {
+		return ErrorRecordNotFound
+	}
+
+	for _, entry := range m.store.collItems[collectionID] {
+		if entry.movieID == movieID {
+			return nil
+		}
+	}
+
+	m.store.collItems[collectionID] = append(m.store.collItems[collectionID], memoryListEntry{
+		movieID: movieID,
+		addedAt: time.Now().Truncate(time.Second),
+	})
+
+	return nil
+}
+
+func (m *MemoryCollectionModel) RemoveMovie(ctx context.Context, collectionID, movieID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	entries := m.store.collItems[collectionID]
+	kept := removeListEntry(entries, movieID)
+	if len(kept) == len(entries) {
+		return ErrorRecordNotFound
+	}
+
+	m.store.collItems[collectionID] = kept
+
+	return nil
+}
+
+func (m *MemoryCollectionModel) Reorder(ctx context.Context, collectionID int64, movieIDs []int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	// Trashed movies aren't listed, so they aren't expected in movieIDs; they
+	// keep their relative order after the rest.
+	var trashed []memoryListEntry
+
+	byMovie := make(map[int64]memoryListEntry)
+	for _, entry := range m.store.collItems[collectionID] {
+		if movie, ok := m.store.movies[entry.movieID]; ok && movie.DeletedAt != nil {
+			trashed = append(trashed, entry)
+			continue
+		}
+		byMovie[entry.movieID] = entry
+	}
+
+	if len(byMovie) != len(movieIDs) {
+		return ErrorInvalidOrder
+	}
+
+	reordered := make([]memoryListEntry, 0, len(movieIDs))
+	for _, movieID := range movieIDs {
+		entry, ok := byMovie[movieID]
+		if !ok {
+			return ErrorInvalidOrder
+		}
+		delete(byMovie, movieID)
+		reordered = append(reordered, entry)
+	}
+
+	m.store.collItems[collectionID] = append(reordered, trashed...)
+
+	return nil
+}
+
+func (m *MemoryCollectionModel) GetMovies(ctx context.Context, collectionID int64, filters Filters) ([]*ListedMovie, Metadata, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	items, metadata := m.store.listMovies(m.store.collItems[collectionID], true, filters)
+
+	return items, metadata, nil
+}
+
+type MemoryUserModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryUserModel) emailTaken(email string, exceptID int64) bool {
+	for _, user := range m.store.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryUserModel) Insert(ctx context.Context, user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return ErrorDublicateEmail
+	}
+
+	m.store.nextUserID++
+
+	user.ID = m.store.nextUserID
+	user.CreatedAt = time.Now().Truncate(time.Second)
+	user.Version = 1
+
+	m.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (m *MemoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	for _, user := range m.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return copyUser(user), nil
+		}
+	}
+
+	return nil, ErrorRecordNotFound
+}
+
+func (m *MemoryUserModel) Update(ctx context.Context, user *User) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrorDublicateEmail
+	}
+
+	stored, ok := m.store.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrorEditConflict
+	}
+
+	user.Version++
+	m.store.users[user.ID] = copyUser(user)
+
+	return nil
+}
+
+func (m *MemoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlainText string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	token, ok := m.store.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrorRecordNotFound
+	}
+
+	user, ok := m.store.users[token.UserID]
+	if !ok {
+		return nil, ErrorRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+type MemoryTokenModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+
+	return token, err
+}
+
+func (m *MemoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	c := *token
+	c.PlainText = ""
+	m.store.tokens[string(token.Hash)] = &c
+
+	return nil
+}
+
+func (m *MemoryTokenModel) DeleteAllForUser(ctx context.Context, userID int64, scope string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.UserID == userID && token.Scope == scope {
+			delete(m.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryTokenModel) DeleteAllScopesForUser(ctx context.Context, userID int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for hash, token := range m.store.tokens {
+		if token.UserID == userID {
+			delete(m.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+type MemoryPermissionModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	return append(Permissions(nil), m.store.permissions[userID]...), nil
+}
+
+func (m *MemoryPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	for _, code := range codes {
+		known := false
+		for _, c := range m.store.permissionSet {
+			if c == code {
+				known = true
+				break
+			}
+		}
+
+		if known && !m.store.permissions[userID].Include(code) {
+			m.store.permissions[userID] = append(m.store.permissions[userID], code)
+		}
+	}
+
+	return nil
+}
+
+type MemoryIdempotencyModel struct {
+	store *memoryStore
+}
+
+func (m *MemoryIdempotencyModel) Begin(ctx context.Context, owner, key string, fingerprint []byte, ttl, lease time.Duration) (*IdempotentResponse, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	id := memoryIdempotencyKey{owner: owner, key: key}
+	now := time.Now()
+
+	request, ok := m.store.idempotency[id]
+
+	stale := ok && request.response == nil && !request.lockedAt.After(now.Add(-lease)) &&
+		bytes.Equal(request.fingerprint, fingerprint)
+
+	if !ok || !request.expiresAt.After(now) || stale {
+		m.store.idempotency[id] = &memoryIdempotentRequest{
+			fingerprint: append([]byte{}, fingerprint...),
+			lockedAt:    now,
+			expiresAt:   now.Add(ttl),
+		}
+		return nil, nil
+	}
+
+	switch {
+	case !bytes.Equal(request.fingerprint, fingerprint):
+		return nil, ErrorIdempotencyKeyMismatch
+	case request.response == nil:
+		return nil, ErrorIdempotencyKeyInUse
+	}
+
+	response := *request.response
+	response.Header = request.response.Header.Clone()
+
+	return &response, nil
+}
+
+func (m *MemoryIdempotencyModel) Complete(ctx context.Context, owner, key string, response *IdempotentResponse) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	request, ok := m.store.idempotency[memoryIdempotencyKey{owner: owner, key: key}]
+	if ok && request.response == nil {
+		request.response = &IdempotentResponse{
+			StatusCode: response.StatusCode,
+			Header:     response.Header.Clone(),
+			Body:       append([]byte{}, response.Body...),
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryIdempotencyModel) Release(ctx context.Context, owner, key string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	id := memoryIdempotencyKey{owner: owner, key: key}
+
+	if request, ok := m.store.idempotency[id]; ok && request.response == nil {
+		delete(m.store.idempotency, id)
+	}
+
+	return nil
+}
+
+func (m *MemoryIdempotencyModel) DeleteExpired(ctx context.Context) (int64, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	var deleted int64
+
+	for id, request := range m.store.idempotency {
+		if !request.expiresAt.After(time.Now()) {
+			delete(m.store.idempotency, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -1,8 +1,10 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 var (
@@ -10,16 +12,123 @@ var (
 	ErrorEditConflict = errors.New("edit conflict")
 )
 
+type MovieRepository interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64, version int32) error
+	GetAll(ctx context.Context, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error)
+	Autocomplete(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error)
+	GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error)
+	Restore(ctx context.Context, id int64) error
+	Purge(ctx context.Context, cutoff time.Time) (int64, error)
+	GetRevisions(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error)
+	GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error)
+	ApplyBatch(ctx context.Context, operations []*MovieBatchOperation, atomic bool) ([]error, error)
+}
+
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlainText string) (*User, error)
+}
+
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, userID int64, scope string) error
+	DeleteAllScopesForUser(ctx context.Context, userID int64) error
+}
+
+type PermissionRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+type ReviewRepository interface {
+	Insert(ctx context.Context, review *Review) error
+	Get(ctx context.Context, movieID, id int64) (*Review, error)
+	Update(ctx context.Context, review *Review) error
+	Delete(ctx context.Context, movieID, id int64) error
+	GetAllForMovie(ctx context.Context, movieID int64, filters Filters) ([]*Review, Metadata, error)
+}
+
+type PersonRepository interface {
+	Insert(ctx context.Context, person *Person) error
+	Get(ctx context.Context, id int64) (*Person, error)
+	Update(ctx context.Context, person *Person) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error)
+	GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error)
+	GetFilmography(ctx context.Context, personID int64) ([]*FilmographyEntry, error)
+}
+
+type WatchlistRepository interface {
+	Add(ctx context.Context, userID, movieID int64) error
+	Remove(ctx context.Context, userID, movieID int64) error
+	GetAll(ctx context.Context, userID int64, filters Filters) ([]*ListedMovie, Metadata, error)
+}
+
+type CollectionRepository interface {
+	Insert(ctx context.Context, collection *Collection) error
+	Get(ctx context.Context, id int64) (*Collection, error)
+	GetByShareToken(ctx context.Context, shareToken string) (*Collection, error)
+	Update(ctx context.Context, collection *Collection) error
+	RotateShareToken(ctx context.Context, collection *Collection) error
+	Delete(ctx context.Context, id int64) error
+	GetAllForUser(ctx context.Context, userID int64, filters Filters) ([]*Collection, Metadata, error)
+	AddMovie(ctx context.Context, collectionID, movieID int64) error
+	RemoveMovie(ctx context.Context, collectionID, movieID int64) error
+	Reorder(ctx context.Context, collectionID int64, movieIDs []int64) error
+	GetMovies(ctx context.Context, collectionID int64, filters Filters) ([]*ListedMovie, Metadata, error)
+}
+
+type IdempotencyRepository interface {
+	Begin(ctx context.Context, owner, key string, fingerprint []byte, ttl, lease time.Duration) (*IdempotentResponse, error)
+	Complete(ctx context.Context, owner, key string, response *IdempotentResponse) error
+	Release(ctx context.Context, owner, key string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
 type Models struct {
-	Movies MovieModel
-	Users UserModel
-	Token TokenModel
+	Movies MovieRepository
+	Users UserRepository
+	Token TokenRepository
+	Permissions PermissionRepository
+	Reviews ReviewRepository
+	People PersonRepository
+	Watchlist WatchlistRepository
+	Collections CollectionRepository
+	Idempotency IdempotencyRepository
 }
 
-func NewMovies(db *sql.DB) Models {
+func NewMovies(db *sql.DB, queryTimeout time.Duration) Models {
+	return Models{
+		Movies: MovieModel{DB: db, Timeout: queryTimeout},
+		Users: &UserModel{DB: db, Timeout: queryTimeout},
+		Token: &TokenModel{DB: db, Timeout: queryTimeout},
+		Permissions: PermissionModel{DB: db, Timeout: queryTimeout},
+		Reviews: ReviewModel{DB: db, Timeout: queryTimeout},
+		People: PersonModel{DB: db, Timeout: queryTimeout},
+		Watchlist: WatchlistModel{DB: db, Timeout: queryTimeout},
+		Collections: CollectionModel{DB: db, Timeout: queryTimeout},
+		Idempotency: IdempotencyModel{DB: db, Timeout: queryTimeout},
+	}
+}
+
+func NewMemoryModels() Models {
+	store := newMemoryStore()
+
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users: UserModel{DB: db},
-		Token: TokenModel{DB: db},
+		Movies: &MemoryMovieModel{store: store},
+		Users: &MemoryUserModel{store: store},
+		Token: &MemoryTokenModel{store: store},
+		Permissions: &MemoryPermissionModel{store: store},
+		Reviews: &MemoryReviewModel{store: store},
+		People: &MemoryPersonModel{store: store},
+		Watchlist: &MemoryWatchlistModel{store: store},
+		Collections: &MemoryCollectionModel{store: store},
+		Idempotency: &MemoryIdempotencyModel{store: store},
 	}
 }
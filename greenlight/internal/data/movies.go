@@ -2,10 +2,14 @@ package data
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	_ "database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 
 	// "fmt"
 	"time"
@@ -15,18 +19,47 @@ import (
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID            int64            `json:"id"`
+	CreatedAt     time.Time        `json:"-"`
+	UpdatedAt     time.Time        `json:"-"`
+	Title         string           `json:"title"`
+	Description   string           `json:"description,omitempty"`
+	Year          int32            `json:"year,omitempty"`
+	Runtime       Runtime          `json:"runtime,omitempty"`
+	Genres        []string         `json:"genres,omitempty"`
+	Version       int32            `json:"version"`
+	// AverageRating and RatingCount summarise the movie's reviews. They are
+	// maintained by the database and ignored by Insert and Update.
+	AverageRating float64          `json:"average_rating"`
+	RatingCount   int32            `json:"rating_count"`
+	DeletedAt     *time.Time       `json:"deleted_at,omitempty"`
+	Relevance     float64          `json:"relevance,omitempty"`
+	Highlights    *MovieHighlights `json:"highlights,omitempty"`
+	// Credits are only set when creating a movie; read them back through
+	// PersonModel.GetCreditsForMovie.
+	Credits []Credit `json:"credits,omitempty"`
+}
+
+// MovieHighlights holds ts_headline snippets for a full-text search, with
+// matches wrapped in <b></b>. They are HTML: the title and description are
+// escaped before highlighting, so the <b> tags are the only markup in them.
+type MovieHighlights struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// SearchLanguages maps the text search configurations a client may ask for to
+// the stored tsvector column built with that configuration.
+var SearchLanguages = map[string]string{
+	"simple":  "search_simple",
+	"english": "search_english",
+	"russian": "search_russian",
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
+	v.Check(len(movie.Description) <= 5000, "description", "must not be more than 5000 bytes long")
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
 	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
@@ -36,59 +69,92 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	ValidateCredits(v, movie.Credits)
 }
 
 type MovieModel struct {
 	DB *sql.DB
+	Timeout time.Duration
 }
 
 
 
-func (m MovieModel) Insert(movie *Movie) error {
+func (m MovieModel) Insert(ctx context.Context, movie *Movie) error {
+
+	ctx, canel := context.WithTimeout(ctx, m.Timeout)
+	defer canel()
+
+	// The movie, its credits and its first revision are written together so
+	// a bad person id doesn't leave an uncredited movie behind.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
+func insertMovie(ctx context.Context, tx *sql.Tx, movie *Movie) error {
 	query := `
-	INSERT INTO movies (title, year, runtime, genres)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at, version`
+	INSERT INTO movies (title, description, year, runtime, genres)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, updated_at, version`
 
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Description, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, canel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer canel()
+	err := tx.QueryRowContext(ctx ,query ,args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
 
+	err = insertCredits(ctx, tx, movie.ID, movie.Credits)
+	if err != nil {
+		return err
+	}
 
-	return m.DB.QueryRowContext(ctx ,query ,args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return insertRevision(ctx, tx, movie.ID, movie.Version, RevisionCreate, nil, stateOf(movie))
 }
 
 
 
-func (m MovieModel) Get(id int64) (*Movie, error) {
+func (m MovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
 
 	if id < 1 {
 		return nil, ErrorRecordNotFound
 	}
 
 	query := `
-				SELECT id, created_at, title, year, runtime, genres, version
+				SELECT id, created_at, updated_at, title, description, year, runtime, genres, version, average_rating, rating_count
 				FROM movies 
-				WHERE id = $1
+				WHERE id = $1 AND deleted_at IS NULL
 			`
 
 	var movie Movie
 
 
-	ctx, canel := context.WithTimeout(context.Background(),3*time.Second)
+	ctx, canel := context.WithTimeout(ctx, m.Timeout)
 
 	defer canel()
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
+		&movie.Description,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
 	)
 	if err != nil {
 		switch {
@@ -105,19 +171,62 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 
 
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(ctx context.Context, movie *Movie) error {
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = updateMovie(ctx, tx, movie)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func updateMovie(ctx context.Context, tx *sql.Tx, movie *Movie) error {
+	// The row is locked while it is read so the revision's "from" values are
+	// the ones this update actually replaces.
+	var before movieState
+
+	err := tx.QueryRowContext(ctx, `
+	SELECT title, description, year, runtime, genres
+	FROM movies
+	WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+	FOR UPDATE`, movie.ID, movie.Version).Scan(
+		&before.Title,
+		&before.Description,
+		&before.Year,
+		&before.Runtime,
+		pq.Array(&before.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorEditConflict
+		default:
+			return err
+		}
+	}
 
 	query := `
 
 			UPDATE movies 
-			SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-			WHERE id = $5 and version = $6
-			RETURNING version 
+			SET title = $1, description = $2, year = $3, runtime = $4, genres = $5, updated_at = NOW(), version = version + 1
+			WHERE id = $6 and version = $7 AND deleted_at IS NULL
+			RETURNING updated_at, version 
 	
 	`
 
 	args := []interface{}{
 		movie.Title,
+		movie.Description,
 		movie.Year,
 		movie.Runtime,
 		pq.Array(movie.Genres),
@@ -125,10 +234,7 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx ,query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx ,query, args...).Scan(&movie.UpdatedAt, &movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -138,61 +244,504 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	return insertRevision(ctx, tx, movie.ID, movie.Version, RevisionUpdate, &before, stateOf(movie))
 }
 
 
 
 
-func (m MovieModel) Delete(id int64) error {
+// Delete moves the movie to the trash. A non-zero version makes the delete
+// conditional: it returns ErrorEditConflict unless the movie is still at that
+// version.
+func (m MovieModel) Delete(ctx context.Context, id int64, version int32) error {
 
 	if id < 1 {
 		return ErrorRecordNotFound
 	}
 
-	query := `
-			DELETE FROM movies 
-			WHERE id = $1
+	return m.setDeleted(ctx, id, version, deleteMovieQuery, RevisionDelete)
+}
 
+// deleteMovieQuery only moves the movie to the trash. It can be restored
+// until Purge removes it for good.
+const deleteMovieQuery = `
+			UPDATE movies
+			SET deleted_at = NOW(), updated_at = NOW(), version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+			RETURNING version, title, description, year, runtime, genres
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetTrash lists the movies that have been deleted but not yet purged.
+func (m MovieModel) GetTrash(ctx context.Context, filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, title, description, year, runtime, genres, version, average_rating, rating_count, deleted_at
+	FROM movies
+	WHERE deleted_at IS NOT NULL
+	ORDER BY %s
+	LIMIT $1 OFFSET $2`, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx,query,id)
-	if err != nil{
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Description,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return movies, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Restore takes a movie back out of the trash. It returns ErrorRecordNotFound
+// if the movie isn't in the trash.
+func (m MovieModel) Restore(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrorRecordNotFound
+	}
+
+	query := `
+	UPDATE movies
+	SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+	WHERE id = $1 AND deleted_at IS NOT NULL AND ($2 = 0 OR version = $2)
+	RETURNING version, title, description, year, runtime, genres`
+
+	return m.setDeleted(ctx, id, 0, query, RevisionRestore)
+}
+
+// setDeleted runs a query that moves a movie into or out of the trash and
+// returns its new version and fields, recording the revision in the same
+// transaction. The query takes the movie's id and, unless it is zero, the
+// version it must be at.
+func (m MovieModel) setDeleted(ctx context.Context, id int64, version int32, query, action string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
 		return err
 	}
-	
-	rowsAffected, err := result.RowsAffected()
-	if err != nil{
+	defer tx.Rollback()
+
+	err = setMovieDeleted(ctx, tx, id, version, query, action)
+	if err != nil {
 		return err
 	}
 
+	return tx.Commit()
+}
 
-	if rowsAffected == 0 {
-		return ErrorRecordNotFound
-	} 
+func setMovieDeleted(ctx context.Context, tx *sql.Tx, id int64, version int32, query, action string) error {
+	var (
+		newVersion int32
+		after      movieState
+	)
+
+	err := tx.QueryRowContext(ctx, query, id, version).Scan(
+		&newVersion,
+		&after.Title,
+		&after.Description,
+		&after.Year,
+		&after.Runtime,
+		pq.Array(&after.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && version != 0:
+			return ErrorEditConflict
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	after.Deleted = action == RevisionDelete
+
+	before := after
+	before.Deleted = !after.Deleted
+
+	return insertRevision(ctx, tx, id, newVersion, action, &before, &after)
+}
+
+// purgeBatchSize is how many movies Purge deletes per transaction, so that a
+// large backlog is worked through within the statement timeout.
+const purgeBatchSize = 500
+
+// Purge permanently deletes the movies that were moved to the trash before
+// the cutoff, returning how many were removed. Each gets a final purge
+// revision; the revisions themselves are kept as the movie's audit trail.
+// Movies are deleted purgeBatchSize at a time, each batch committed on its
+// own, so a failure part way through keeps the batches already purged.
+func (m MovieModel) Purge(ctx context.Context, cutoff time.Time) (int64, error) {
+	var total int64
+
+	for {
+		purged, err := m.purgeBatch(ctx, cutoff)
+		total += int64(purged)
+		if err != nil {
+			return total, err
+		}
+
+		if purged < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func (m MovieModel) purgeBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	query := `
+	DELETE FROM movies
+	WHERE id IN (
+		SELECT id FROM movies
+		WHERE deleted_at < $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, version, title, description, year, runtime, genres`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, cutoff, purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var purged []*Movie
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Version,
+			&movie.Title,
+			&movie.Description,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+		)
+		if err != nil {
+			return 0, err
+		}
+
+		purged = append(purged, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, movie := range purged {
+		before := stateOf(movie)
+		before.Deleted = true
+
+		err = insertRevision(ctx, tx, movie.ID, movie.Version+1, RevisionPurge, before, nil)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(purged), nil
+}
+
+// MovieFilter narrows the movies listing. Zero values mean "no filter".
+type MovieFilter struct {
+	Title         string
+	Search        string
+	Language      string
+	Highlight     bool
+	Fuzzy         bool
+	Genres        []string
+	AnyGenres     []string
+	ExcludeGenres []string
+	YearMin       int
+	YearMax       int
+	RuntimeMin    int
+	RuntimeMax    int
+	CreatedAfter  time.Time
+	Director      string
+	Actor         string
+}
+
+// CursorScope hashes the filter for Filters.CursorScope, so a cursor can't be
+// carried over to a listing with different filters. Highlight is left out,
+// since it doesn't change which movies are listed.
+func (f MovieFilter) CursorScope() string {
+	f.Highlight = false
+
+	js, err := json.Marshal(f)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(js)
+
+	return hex.EncodeToString(sum[:16])
+}
+
+func ValidateMovieFilter(v *validator.Validator, f MovieFilter) {
+	currentYear := time.Now().Year()
+
+	if f.YearMin != 0 {
+		v.Check(f.YearMin >= 1888, "year_min", "must be greater than 1888")
+		v.Check(f.YearMin <= currentYear, "year_min", "must not be in the future")
+	}
+	if f.YearMax != 0 {
+		v.Check(f.YearMax >= 1888, "year_max", "must be greater than 1888")
+		v.Check(f.YearMin == 0 || f.YearMax >= f.YearMin, "year_max", "must not be less than year_min")
+	}
+
+	if f.RuntimeMin != 0 {
+		v.Check(f.RuntimeMin > 0, "runtime_min", "must be a positive integer")
+	}
+	if f.RuntimeMax != 0 {
+		v.Check(f.RuntimeMax > 0, "runtime_max", "must be a positive integer")
+		v.Check(f.RuntimeMin == 0 || f.RuntimeMax >= f.RuntimeMin, "runtime_max", "must not be less than runtime_min")
+	}
+
+	if !f.CreatedAfter.IsZero() {
+		v.Check(f.CreatedAfter.Before(time.Now()), "created_after", "must not be in the future")
+	}
+
+	if f.Search != "" {
+		v.Check(len(f.Search) <= 500, "q", "must not be more than 500 bytes long")
+	}
+	_, ok := SearchLanguages[f.Language]
+	v.Check(f.Language == "" || ok, "language", "must be one of simple, english or russian")
+	v.Check(!f.Highlight || f.Search != "", "highlight", "requires a q search term")
+
+	v.Check(len(f.Director) <= 500, "director", "must not be more than 500 bytes long")
+	v.Check(len(f.Actor) <= 500, "actor", "must not be more than 500 bytes long")
+
+	v.Check(len(f.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(len(f.AnyGenres) <= 5, "any_genre", "must not contain more than 5 genres")
+	v.Check(len(f.ExcludeGenres) <= 5, "exclude_genre", "must not contain more than 5 genres")
+
+	for _, genre := range f.ExcludeGenres {
+		v.Check(!validator.In(genre, f.Genres...), "exclude_genre", "must not contain a genre that is also required")
+	}
+}
+
+func (f MovieFilter) searchColumn() string {
+	if column, ok := SearchLanguages[f.Language]; ok {
+		return column
+	}
+	return SearchLanguages["simple"]
+}
+
+func (f MovieFilter) searchLanguage() string {
+	if _, ok := SearchLanguages[f.Language]; ok {
+		return f.Language
+	}
+	return "simple"
+}
+
+// conditions returns the WHERE conditions for the filter, with placeholders
+// numbered from firstArg. Only the filters that are set are included, so the
+// planner can use the matching indexes. When there is a full-text or fuzzy
+// search, search holds its SQL expressions for reuse in ranking and
+// highlighting.
+func (f MovieFilter) conditions(firstArg int) (conds []string, args []interface{}, search *textSearch) {
+	if f.Search != "" {
+		config := fmt.Sprintf("$%d::regconfig", firstArg)
+		search = &textSearch{
+			config: config,
+			query:  fmt.Sprintf("websearch_to_tsquery(%s, $%d)", config, firstArg+1),
+		}
+		// The rank is real; it is widened here so the value compared against
+		// a cursor is the float64 that was written into it.
+		search.rank = fmt.Sprintf("ts_rank_cd(%s, %s)::float8", f.searchColumn(), search.query)
+		args = append(args, f.searchLanguage(), f.Search)
+		conds = append(conds, fmt.Sprintf("%s @@ %s", f.searchColumn(), search.query))
+	}
+
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, fmt.Sprintf(cond, firstArg+len(args)))
+		args = append(args, arg)
+	}
+
+	switch {
+	case f.Title != "" && f.Fuzzy:
+		// Trigram word similarity tolerates typos that plainto_tsquery
+		// can't match. It ranks results unless there is a q search too,
+		// widened to float8 like ts_rank_cd.
+		add("$%d <%% title", f.Title)
+		if search == nil {
+			search = &textSearch{rank: fmt.Sprintf("word_similarity($%d, title)::float8", firstArg+len(args)-1)}
+		}
+	case f.Title != "":
+		add("to_tsvector('simple', title) @@ plainto_tsquery('simple', $%d)", f.Title)
+	}
+	if len(f.Genres) > 0 {
+		add("genres @> $%d", pq.Array(f.Genres))
+	}
+	if len(f.AnyGenres) > 0 {
+		add("genres && $%d", pq.Array(f.AnyGenres))
+	}
+	if len(f.ExcludeGenres) > 0 {
+		add("NOT (genres && $%d)", pq.Array(f.ExcludeGenres))
+	}
+	if f.YearMin != 0 {
+		add("year >= $%d", f.YearMin)
+	}
+	if f.YearMax != 0 {
+		add("year <= $%d", f.YearMax)
+	}
+	if f.RuntimeMin != 0 {
+		add("runtime >= $%d", f.RuntimeMin)
+	}
+	if f.RuntimeMax != 0 {
+		add("runtime <= $%d", f.RuntimeMax)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at > $%d", f.CreatedAfter)
+	}
+	if f.Director != "" {
+		add(creditedAs("director"), f.Director)
+	}
+	if f.Actor != "" {
+		add(creditedAs("actor"), f.Actor)
+	}
+
+	return conds, args, search
+}
+
+// creditedAs returns a condition format matching movies that credit a person,
+// named by the placeholder, in role. Names are compared case-insensitively.
+func creditedAs(role string) string {
+	return `EXISTS (
+				SELECT 1 FROM movie_credits
+				INNER JOIN people ON people.id = movie_credits.person_id
+				WHERE movie_credits.movie_id = movies.id AND movie_credits.role = '` + role + `'
+				AND lower(people.name) = lower($%d))`
+}
+
+// htmlEscaped returns an expression escaping column the way html.EscapeString
+// does. ts_headline's parser reads the entities as single tokens, so escaping
+// doesn't change which words match.
+func htmlEscaped(column string) string {
+	return `replace(replace(replace(replace(replace(` + column +
+		`, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
 
-	return nil
+type textSearch struct {
+	config string
+	query  string
+	rank   string
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error){
+func (m MovieModel) GetAll(ctx context.Context, filter MovieFilter, filters Filters) ([]*Movie, Metadata, error){
+
+	cursor, err := filters.cursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	conds, args, search := filter.conditions(1)
+
+	// In page-number mode the total is counted alongside the rows; keyset
+	// mode skips the window function and fetches one extra row instead, to
+	// tell whether another page follows.
+	countExpr := "count(*) OVER()"
+	keyset := "TRUE"
+	pagination := fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	reverse := false
+
+	if cursor != nil {
+		var keysetArgs []interface{}
+
+		countExpr = "0"
+		keyset, keysetArgs = filters.keysetCondition(cursor, len(args)+1)
+		args = append(args, keysetArgs...)
+		pagination = fmt.Sprintf("LIMIT $%d", len(args)+1)
+		args = append(args, filters.limit()+1)
+		reverse = cursor.Backward
+	} else {
+		args = append(args, filters.limit(), filters.offset())
+	}
+
+	where := strings.Join(append([]string{"deleted_at IS NULL"}, conds...), "\n\t\t\tAND ")
+
+	rankExpr := "0"
+	titleHeadline, descriptionHeadline := "''", "''"
+
+	if search != nil {
+		rankExpr = search.rank
 
+		if filter.Highlight && search.query != "" {
+			titleHeadline = fmt.Sprintf("ts_headline(%s, %s, %s, 'HighlightAll=true')", search.config, htmlEscaped("title"), search.query)
+			descriptionHeadline = fmt.Sprintf("ts_headline(%s, %s, %s, 'MaxFragments=2, MinWords=10, MaxWords=30')", search.config, htmlEscaped("description"), search.query)
+		}
+	}
+
+	// The innermost query computes relevance so the keyset condition and
+	// ORDER BY can refer to it by name like any other column. Headlines are
+	// only generated for the rows on the page, since ts_headline is costly.
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`,filters.sortColumn(), filters.sortDiraction())
+	WITH page AS (
+		SELECT %s AS total, * FROM (
+			SELECT id, created_at, updated_at, title, description, year, runtime, genres, version, average_rating, rating_count, %s AS relevance
+			FROM movies
+			WHERE %s
+		) m
+		WHERE %s
+		ORDER BY %s
+		%s
+	)
+	SELECT total, id, created_at, updated_at, title, description, year, runtime, genres, version, average_rating, rating_count, relevance, %s, %s
+	FROM page
+	ORDER BY %s`, countExpr, rankExpr, where, keyset, filters.orderBy(reverse), pagination,
+		titleHeadline, descriptionHeadline, filters.orderBy(reverse))
 		
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil{
 		return nil, Metadata{} ,err
@@ -204,22 +753,36 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	movies := []*Movie{}
 
 	for rows.Next(){
-		var movie Movie
+		var (
+			movie      Movie
+			highlights MovieHighlights
+		)
 
 		err := rows.Scan(
 			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.UpdatedAt,
 			&movie.Title,
+			&movie.Description,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.Relevance,
+			&highlights.Title,
+			&highlights.Description,
 		)
 		if err != nil{
 			return nil, Metadata{} ,err
 		}
 
+		if filter.Highlight {
+			movie.Highlights = &highlights
+		}
+
 		movies = append(movies, &movie)
 	}
 
@@ -227,7 +790,149 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{} ,err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return paginateMovies(filters, cursor, movies, totalRecords)
+}
+
+// MovieSuggestion is a single autocomplete result.
+type MovieSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year"`
+}
+
+func ValidateAutocomplete(v *validator.Validator, prefix string, limit int) {
+	v.Check(prefix != "", "q", "must be provided")
+	v.Check(len(prefix) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+}
+
+// Autocomplete returns the titles closest to what the user has typed so far.
+// It uses the trigram GiST index for both the <% filter and the <<-> nearest
+// neighbour ordering, so the cost stays flat as the table grows.
+func (m MovieModel) Autocomplete(ctx context.Context, prefix string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+	SELECT id, title, year
+	FROM movies
+	WHERE $1 <% title AND deleted_at IS NULL
+	ORDER BY $1 <<-> title, id
+	LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+
+	for rows.Next() {
+		var suggestion MovieSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+func movieSortValue(movie *Movie, column string) interface{} {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return int64(movie.Year)
+	case "runtime":
+		return int64(movie.Runtime)
+	case "relevance":
+		return movie.Relevance
+	case "average_rating":
+		return movie.AverageRating
+	case "rating_count":
+		return int64(movie.RatingCount)
+	case "deleted_at":
+		if movie.DeletedAt == nil {
+			return float64(0)
+		}
+		return float64(movie.DeletedAt.UnixNano())
+	default:
+		return movie.ID
+	}
+}
+
+func movieCursor(filters Filters, movie *Movie, backward bool) (string, error) {
+	fields := filters.sortFields()
+
+	values := make([]interface{}, 0, len(fields)-1)
+	for _, field := range fields[:len(fields)-1] {
+		values = append(values, movieSortValue(movie, field.column))
+	}
+
+	return filters.newCursor(values, movie.ID, backward)
+}
+
+// paginateMovies turns the rows fetched for one page into the page itself and
+// its metadata. In keyset mode rows holds up to limit+1 movies in fetch order;
+// in page-number mode it holds the page and totalRecords is the full count.
+func paginateMovies(filters Filters, cursor *Cursor, movies []*Movie, totalRecords int) ([]*Movie, Metadata, error) {
+	var (
+		metadata Metadata
+		hasNext  bool
+		hasPrev  bool
+	)
+
+	if cursor == nil {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		hasNext = filters.offset()+len(movies) < totalRecords
+		hasPrev = filters.Page > 1
+	} else {
+		more := len(movies) > filters.limit()
+		if more {
+			movies = movies[:filters.limit()]
+		}
+
+		if cursor.Backward {
+			for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+				movies[i], movies[j] = movies[j], movies[i]
+			}
+			hasPrev, hasNext = more, true
+		} else {
+			hasPrev, hasNext = true, more
+		}
+
+		metadata = Metadata{PageSize: filters.PageSize}
+	}
+
+	if len(movies) == 0 {
+		return movies, metadata, nil
+	}
+
+	var err error
+
+	if hasNext {
+		metadata.NextCursor, err = movieCursor(filters, movies[len(movies)-1], false)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	if hasPrev {
+		metadata.PrevCursor, err = movieCursor(filters, movies[0], true)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
 
 	return movies, metadata, nil
-} 
\ No newline at end of file
+}
@@ -0,0 +1,342 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.rasulabduvaitov.net/internal/validator"
+)
+
+var (
+	ErrorUnknownPerson = errors.New("unknown person")
+)
+
+// CreditRoles are the parts a person can have in a movie.
+var CreditRoles = []string{"director", "actor", "writer"}
+
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// Credit links a person to a movie in one role. Name is filled in when
+// credits are read back and is ignored on input.
+type Credit struct {
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name,omitempty"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+// FilmographyEntry is one credit on a person's filmography.
+type FilmographyEntry struct {
+	MovieID   int64  `json:"movie_id"`
+	Title     string `json:"title"`
+	Year      int32  `json:"year,omitempty"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.Check(len(person.Biography) <= 5000, "biography", "must not be more than 5000 bytes long")
+
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+}
+
+func ValidateCredits(v *validator.Validator, credits []Credit) {
+	v.Check(len(credits) <= 100, "credits", "must not contain more than 100 credits")
+
+	seen := make(map[string]bool)
+
+	for _, credit := range credits {
+		v.Check(credit.PersonID > 0, "credits", "must reference a person by id")
+		v.Check(validator.In(credit.Role, CreditRoles...), "credits", "role must be one of director, actor or writer")
+		v.Check(credit.Character == "" || credit.Role == "actor", "credits", "character can only be given for actors")
+		v.Check(len(credit.Character) <= 500, "credits", "character must not be more than 500 bytes long")
+
+		key := fmt.Sprintf("%d/%s", credit.PersonID, credit.Role)
+		v.Check(!seen[key], "credits", "must not credit the same person in the same role more than once")
+		seen[key] = true
+	}
+}
+
+type PersonModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (m PersonModel) Insert(ctx context.Context, person *Person) error {
+	query := `
+	INSERT INTO people (name, birth_year, biography)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at, version`
+
+	args := []interface{}{person.Name, person.BirthYear, person.Biography}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(ctx context.Context, id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrorRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, name, birth_year, biography, version
+	FROM people
+	WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+func (m PersonModel) Update(ctx context.Context, person *Person) error {
+	query := `
+	UPDATE people
+	SET name = $1, birth_year = $2, biography = $3, version = version + 1
+	WHERE id = $4 AND version = $5
+	RETURNING version`
+
+	args := []interface{}{person.Name, person.BirthYear, person.Biography, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m PersonModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrorRecordNotFound
+	}
+
+	query := `
+	DELETE FROM people
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrorRecordNotFound
+	}
+
+	return nil
+}
+
+func (m PersonModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, name, birth_year, biography, version
+	FROM people
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return people, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetCreditsForMovie returns a movie's cast and crew in the order they were
+// credited.
+func (m PersonModel) GetCreditsForMovie(ctx context.Context, movieID int64) ([]*Credit, error) {
+	query := `
+	SELECT movie_credits.person_id, people.name, movie_credits.role, movie_credits.character
+	FROM movie_credits
+	INNER JOIN people ON people.id = movie_credits.person_id
+	WHERE movie_credits.movie_id = $1
+	ORDER BY movie_credits.position, movie_credits.person_id`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(&credit.PersonID, &credit.Name, &credit.Role, &credit.Character)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// GetFilmography returns every credit a person has, newest movie first.
+func (m PersonModel) GetFilmography(ctx context.Context, personID int64) ([]*FilmographyEntry, error) {
+	query := `
+	SELECT movies.id, movies.title, movies.year, movie_credits.role, movie_credits.character
+	FROM movie_credits
+	INNER JOIN movies ON movies.id = movie_credits.movie_id
+	WHERE movie_credits.person_id = $1 AND movies.deleted_at IS NULL
+	ORDER BY movies.year DESC, movies.id, movie_credits.role`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, personID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*FilmographyEntry{}
+
+	for rows.Next() {
+		var entry FilmographyEntry
+
+		err := rows.Scan(&entry.MovieID, &entry.Title, &entry.Year, &entry.Role, &entry.Character)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// insertCredits adds a movie's credits in one statement, keeping the order
+// they were given in as the billing position.
+func insertCredits(ctx context.Context, tx *sql.Tx, movieID int64, credits []Credit) error {
+	if len(credits) == 0 {
+		return nil
+	}
+
+	personIDs := make([]int64, len(credits))
+	roles := make([]string, len(credits))
+	characters := make([]string, len(credits))
+
+	for i, credit := range credits {
+		personIDs[i] = credit.PersonID
+		roles[i] = credit.Role
+		characters[i] = credit.Character
+	}
+
+	query := `
+	INSERT INTO movie_credits (movie_id, person_id, role, character, position)
+	SELECT $1, c.person_id, c.role, c.character, c.position
+	FROM unnest($2::bigint[], $3::text[], $4::text[]) WITH ORDINALITY AS c(person_id, role, character, position)`
+
+	_, err := tx.ExecContext(ctx, query, movieID, pq.Array(personIDs), pq.Array(roles), pq.Array(characters))
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrorUnknownPerson
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
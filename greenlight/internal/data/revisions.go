@@ -0,0 +1,289 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Revision actions.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionPurge   = "purge"
+)
+
+// MovieRevision records one change to a movie: who made it, what changed and
+// the state the movie was left in. Snapshot is only filled in when a single
+// revision is fetched.
+type MovieRevision struct {
+	MovieID   int64           `json:"movie_id"`
+	Version   int32           `json:"version"`
+	Action    string          `json:"action"`
+	ActorID   *int64          `json:"actor_id"`
+	RequestID string          `json:"request_id,omitempty"`
+	Diff      json.RawMessage `json:"diff"`
+	Snapshot  json.RawMessage `json:"snapshot,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Actor identifies who is making a change, for the audit trail. Handlers put
+// it on the context with ContextWithActor before calling the movie model.
+type Actor struct {
+	UserID    int64
+	RequestID string
+}
+
+type actorContextKey struct{}
+
+func ContextWithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+func actorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorContextKey{}).(Actor)
+	return actor
+}
+
+// movieState is the part of a movie that revisions track.
+type movieState struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Year        int32    `json:"year"`
+	Runtime     Runtime  `json:"runtime"`
+	Genres      []string `json:"genres"`
+	Deleted     bool     `json:"deleted"`
+}
+
+func stateOf(movie *Movie) *movieState {
+	return &movieState{
+		Title:       movie.Title,
+		Description: movie.Description,
+		Year:        movie.Year,
+		Runtime:     movie.Runtime,
+		Genres:      movie.Genres,
+		Deleted:     movie.DeletedAt != nil,
+	}
+}
+
+// revisionChange is one field's entry in a revision diff.
+type revisionChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// diffMovieStates returns a JSON object with a {"from", "to"} entry for every
+// field that differs between before and after. before is nil for a new movie.
+func diffMovieStates(before, after *movieState) (json.RawMessage, error) {
+	toMap := func(state *movieState) (map[string]interface{}, error) {
+		fields := map[string]interface{}{}
+		if state == nil {
+			return fields, nil
+		}
+
+		js, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(js, &fields)
+		return fields, err
+	}
+
+	from, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]revisionChange{}
+	for field, value := range to {
+		if !reflect.DeepEqual(from[field], value) {
+			diff[field] = revisionChange{From: from[field], To: value}
+		}
+	}
+	for field, value := range from {
+		if _, ok := to[field]; !ok {
+			diff[field] = revisionChange{From: value}
+		}
+	}
+
+	return json.Marshal(diff)
+}
+
+// newMovieRevision builds the revision for a change from before to after,
+// taking the actor from the context.
+func newMovieRevision(ctx context.Context, movieID int64, version int32, action string, before, after *movieState) (*MovieRevision, error) {
+	diff, err := diffMovieStates(before, after)
+	if err != nil {
+		return nil, err
+	}
+
+	// A purge leaves no state behind, so its snapshot keeps the last one.
+	state := after
+	if state == nil {
+		state = before
+	}
+
+	snapshot, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &MovieRevision{
+		MovieID:   movieID,
+		Version:   version,
+		Action:    action,
+		RequestID: actorFromContext(ctx).RequestID,
+		Diff:      diff,
+		Snapshot:  snapshot,
+	}
+
+	if userID := actorFromContext(ctx).UserID; userID != 0 {
+		revision.ActorID = &userID
+	}
+
+	return revision, nil
+}
+
+// ApplyTo copies the revision's snapshot onto movie, leaving its ID, version
+// and other fields alone. The result can be saved with MovieRepository.Update
+// to revert the movie to this revision.
+func (r *MovieRevision) ApplyTo(movie *Movie) error {
+	var state movieState
+
+	err := json.Unmarshal(r.Snapshot, &state)
+	if err != nil {
+		return err
+	}
+
+	movie.Title = state.Title
+	movie.Description = state.Description
+	movie.Year = state.Year
+	movie.Runtime = state.Runtime
+	movie.Genres = state.Genres
+
+	return nil
+}
+
+// insertRevision records a change to a movie as part of the transaction that
+// made it.
+func insertRevision(ctx context.Context, tx *sql.Tx, movieID int64, version int32, action string, before, after *movieState) error {
+	revision, err := newMovieRevision(ctx, movieID, version, action, before, after)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO movie_revisions (movie_id, version, action, actor_id, request_id, diff, snapshot)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	args := []interface{}{
+		revision.MovieID,
+		revision.Version,
+		revision.Action,
+		revision.ActorID,
+		revision.RequestID,
+		[]byte(revision.Diff),
+		[]byte(revision.Snapshot),
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetRevisions lists a movie's revisions a page at a time, without their
+// snapshots.
+func (m MovieModel) GetRevisions(ctx context.Context, movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), movie_id, version, action, actor_id, request_id, diff, created_at
+	FROM movie_revisions
+	WHERE movie_id = $1
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Action,
+			&revision.ActorID,
+			&revision.RequestID,
+			(*[]byte)(&revision.Diff),
+			&revision.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return revisions, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m MovieModel) GetRevision(ctx context.Context, movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrorRecordNotFound
+	}
+
+	query := `
+	SELECT movie_id, version, action, actor_id, request_id, diff, snapshot, created_at
+	FROM movie_revisions
+	WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&revision.ActorID,
+		&revision.RequestID,
+		(*[]byte)(&revision.Diff),
+		(*[]byte)(&revision.Snapshot),
+		&revision.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
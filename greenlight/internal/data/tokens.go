@@ -14,14 +14,16 @@ import (
 
 const (
 	ScopeActiation = "activation"
+	ScopeAuthentication = "authentication"
+	ScopePasswordReset = "password-reset"
 )
 
 type Token struct {
-	PlainText string
-	Hash []byte
-	UserID int64
-	Expiry time.Time
-	Scope string
+	PlainText string `json:"token"`
+	Hash []byte `json:"-"`
+	UserID int64 `json:"-"`
+	Expiry time.Time `json:"expiry"`
+	Scope string `json:"-"`
 }
 
 
@@ -58,24 +60,25 @@ func ValidateTokenPlainText(v *validator.Validator, tokenPlainText string)  {
 
 type TokenModel struct {
 	DB *sql.DB
+	Timeout time.Duration
 }
 
 
-func (m *TokenModel) New(UserID int64, ttl time.Duration, scope string) (*Token, error) {
+func (m *TokenModel) New(ctx context.Context, UserID int64, ttl time.Duration, scope string) (*Token, error) {
 
 	token, err := generateToken(UserID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = m.Insert(token)
+	err = m.Insert(ctx, token)
 
 	return token, err
 
 }
 
 
-func (m *TokenModel) Insert(token *Token) error {
+func (m *TokenModel) Insert(ctx context.Context, token *Token) error {
 
 	query := `
 	
@@ -87,7 +90,7 @@ func (m *TokenModel) Insert(token *Token) error {
 
 	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 
-	ctx , cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_,err := m.DB.ExecContext(ctx, query, args...)
@@ -97,19 +100,37 @@ func (m *TokenModel) Insert(token *Token) error {
 }
 
 
-func (m *TokenModel) DeleteAllForUser(UserID int64, scope string) error {
+func (m *TokenModel) DeleteAllForUser(ctx context.Context, UserID int64, scope string) error {
 
 	query := `
 			DELETE FROM tokens
-			WHERE scope = $1, user_id = $2
+			WHERE scope = $1 AND user_id = $2
 
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, UserID)
 
 	return err
 
+}
+
+
+func (m *TokenModel) DeleteAllScopesForUser(ctx context.Context, UserID int64) error {
+
+	query := `
+			DELETE FROM tokens
+			WHERE user_id = $1
+
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, UserID)
+
+	return err
+
 }
\ No newline at end of file
@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
-	"fmt"
 	"time"
 
 	"github.com/lib/pq"
@@ -18,9 +17,12 @@ var (
 	ErrorDublicateEmail = errors.New("dublicate email")  
 )
 
+var AnonymousUser = &User{}
+
 
 type UserModel struct {
 	DB *sql.DB
+	Timeout time.Duration
 }
 
 
@@ -35,6 +37,10 @@ type User struct {
 
 }
 
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
 type password struct {
 	plaintext *string
 	hash []byte
@@ -58,7 +64,7 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	if err != nil {
 		switch {
 		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, err
+			return false, nil
 		default:
 			return false, err
 		}
@@ -96,7 +102,7 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 				INSERT INTO users (name, email, password_hash, activated)
 				VALUES ($1, $2, $3, $4)
@@ -106,7 +112,7 @@ func (m UserModel) Insert(user *User) error {
 
 	args := []interface{}{user.Name, user.Email, string(user.Password.hash), user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 
@@ -128,17 +134,17 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m *UserModel) GetByEmail(email string) (*User, error) {
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-			SELECT id, created_at, name, email, password_hash, activate, version
+			SELECT id, created_at, name, email, password_hash, activated, version
 			FROM users 
-			WHERE email == $1
+			WHERE email = $1
 	
 	`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 
@@ -164,7 +170,7 @@ func (m *UserModel) GetByEmail(email string) (*User, error) {
 }
 
 
-func (m *UserModel) Update(user *User) error {
+func (m *UserModel) Update(ctx context.Context, user *User) error {
 
 	query := `
 			UPDATE users
@@ -182,7 +188,7 @@ func (m *UserModel) Update(user *User) error {
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -191,6 +197,9 @@ func (m *UserModel) Update(user *User) error {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrorDublicateEmail
 
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrorEditConflict
+
 		default:
 			return err
 		}
@@ -201,7 +210,7 @@ func (m *UserModel) Update(user *User) error {
 }
 
 
-func (m *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
+func (m *UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlainText string) (*User, error) {
 
 	tokenHash  := sha256.Sum256([]byte(tokenPlainText))
 
@@ -215,12 +224,12 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error
 	AND tokens.scope = $2
 	AND tokens.expiry > $3`
 
-	args := []interface{}{tokenHash[:], tokenPlainText, time.Now()}
+	args := []interface{}{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
 
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -233,8 +242,6 @@ func (m *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error
 		&user.Version,
 	)
 
-	fmt.Println(user.ID)
-
 	if err != nil {
 
 		switch {
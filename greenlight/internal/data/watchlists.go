@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ListedMovie is a movie as it appears in a watchlist or collection, along
+// with when it was added and, for collections, its place in the list.
+type ListedMovie struct {
+	*Movie
+	AddedAt  time.Time `json:"added_at"`
+	Position int32     `json:"position,omitempty"`
+}
+
+// listedMovieColumns are the columns selected for a ListedMovie, in the order
+// scanListedMovie reads them.
+const listedMovieColumns = `id, created_at, title, description, year, runtime, genres, version, average_rating, rating_count, added_at, position`
+
+func scanListedMovie(rows *sql.Rows, totalRecords *int) (*ListedMovie, error) {
+	item := ListedMovie{Movie: &Movie{}}
+
+	err := rows.Scan(
+		totalRecords,
+		&item.ID,
+		&item.CreatedAt,
+		&item.Title,
+		&item.Description,
+		&item.Year,
+		&item.Runtime,
+		pq.Array(&item.Genres),
+		&item.Version,
+		&item.AverageRating,
+		&item.RatingCount,
+		&item.AddedAt,
+		&item.Position,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+type WatchlistModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Add puts a movie on the user's watchlist. Adding a movie that is already
+// there leaves it where it is. Movies in the trash can't be added.
+func (m WatchlistModel) Add(ctx context.Context, userID, movieID int64) error {
+	query := `
+	WITH movie AS (
+		SELECT id FROM movies WHERE id = $2 AND deleted_at IS NULL
+	), inserted AS (
+		INSERT INTO watchlist_items (user_id, movie_id)
+		SELECT $1, movie.id FROM movie
+		ON CONFLICT DO NOTHING
+	)
+	SELECT EXISTS (SELECT 1 FROM movie)`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var found bool
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(&found)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23503":
+			return ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if !found {
+		return ErrorRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WatchlistModel) Remove(ctx context.Context, userID, movieID int64) error {
+	query := `
+	DELETE FROM watchlist_items
+	WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrorRecordNotFound
+	}
+
+	return nil
+}
+
+func (m WatchlistModel) GetAll(ctx context.Context, userID int64, filters Filters) ([]*ListedMovie, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM (
+		SELECT movies.id, movies.created_at, title, description, year, runtime, genres, version,
+			average_rating, rating_count, watchlist_items.added_at, 0 AS position
+		FROM watchlist_items
+		INNER JOIN movies ON movies.id = watchlist_items.movie_id
+		WHERE watchlist_items.user_id = $1 AND movies.deleted_at IS NULL
+	) m
+	ORDER BY %s
+	LIMIT $2 OFFSET $3`, listedMovieColumns, filters.orderBy(false))
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*ListedMovie{}
+
+	for rows.Next() {
+		item, err := scanListedMovie(rows, &totalRecords)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return items, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -2,9 +2,11 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,7 +14,9 @@ import (
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,8 +24,12 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "Debug"
 	case LevelInfo:
 		return "Info"
+	case LevelWarn:
+		return "Warn"
 	case LevelError:
 		return "Error"
 	case LevelFatal:
@@ -31,10 +39,32 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel converts a level name such as "debug" or "warn", in any case,
+// into a Level.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	case "off":
+		return LevelOff, nil
+	default:
+		return LevelOff, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	out         io.Writer
+	minLevel    Level
+	stackTraces bool
+	mu          sync.Mutex
 }
 
 
@@ -45,30 +75,51 @@ func New(out io.Writer, minLevel Level) *Logger {
 	}
 }
 
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
+// SetStackTraces controls whether Error and Fatal entries include the stack
+// of the goroutine that wrote them. They are off by default.
+func (l *Logger) SetStackTraces(enabled bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stackTraces = enabled
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.minLevel && l.minLevel < LevelOff
+}
+
+func (l *Logger) PrintDebug(message string, properties map[string]interface{}) {
+	l.print(LevelDebug, message, properties)
+}
+
+func (l *Logger) PrintInfo(message string, properties map[string]interface{}) {
 	l.print(LevelInfo, message, properties)
 }
 
-func (l *Logger) PrintError(err error, properties map[string]string) {
+func (l *Logger) PrintWarn(message string, properties map[string]interface{}) {
+	l.print(LevelWarn, message, properties)
+}
+
+func (l *Logger) PrintError(err error, properties map[string]interface{}) {
 	l.print(LevelError, err.Error(), properties)
 }
 
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
+func (l *Logger) PrintFatal(err error, properties map[string]interface{}) {
 	l.print(LevelFatal, err.Error(), properties)
-	os.Exit(1) 
+	os.Exit(1)
 	}
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error){
-	
-	if level < l.minLevel{
+func (l *Logger) print(level Level, message string, properties map[string]interface{}) (int, error){
+
+	if !l.Enabled(level) {
 		return 0, nil
 	}
-	
+
 	aux := struct {
 		Level string `json:"level"`
 		Time string `json:"time"`
 		Message string `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
 		Trace string `json:"trace,omitempty"`
 	}{
 		Level: level.String(),
@@ -77,9 +128,11 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		Properties: properties,
 	}
 
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack()) 
+	if level >= LevelError && l.stackTraces {
+		aux.Trace = string(debug.Stack())
 	}
 
 	var line []byte
@@ -88,10 +141,6 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	if err != nil {
 		line = []byte(LevelError.String()+ "unable to marshal log message: "+err.Error())
 	}
-	
-
-	l.mu.Lock()
-	defer l.mu.Unlock()
 
 	return l.out.Write(append(line, '\n'))
 }
@@ -99,4 +148,4 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 
 func (l *Logger) Write(message []byte) (n int, err error) {
 	return l.print(LevelInfo, string(message), nil)
-}
\ No newline at end of file
+}
@@ -0,0 +1,126 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// SlogHandler is a slog.Handler that writes records through a Logger, so
+// libraries using the standard structured logger produce the same JSON lines
+// as the rest of the application. Attributes become properties; groups are
+// flattened into dotted keys.
+type SlogHandler struct {
+	logger *Logger
+	attrs  map[string]interface{}
+	prefix string
+}
+
+func NewSlogHandler(logger *Logger) *SlogHandler {
+	return &SlogHandler{logger: logger}
+}
+
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level >= slog.LevelError:
+		return LevelError
+	case level >= slog.LevelWarn:
+		return LevelWarn
+	case level >= slog.LevelInfo:
+		return LevelInfo
+	default:
+		return LevelDebug
+	}
+}
+
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.logger.Enabled(levelFromSlog(level))
+}
+
+func (h *SlogHandler) Handle(ctx context.Context, record slog.Record) error {
+	var properties map[string]interface{}
+
+	if len(h.attrs) > 0 || record.NumAttrs() > 0 {
+		properties = make(map[string]interface{}, len(h.attrs)+record.NumAttrs())
+
+		for k, v := range h.attrs {
+			properties[k] = v
+		}
+
+		record.Attrs(func(a slog.Attr) bool {
+			addAttr(properties, h.prefix, a)
+			return true
+		})
+	}
+
+	_, err := h.logger.print(levelFromSlog(record.Level), record.Message, properties)
+	return err
+}
+
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := h.clone()
+
+	for _, a := range attrs {
+		addAttr(h2.attrs, h2.prefix, a)
+	}
+
+	return h2
+}
+
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := h.clone()
+	h2.prefix = h.prefix + name + "."
+
+	return h2
+}
+
+func (h *SlogHandler) clone() *SlogHandler {
+	attrs := make(map[string]interface{}, len(h.attrs))
+	for k, v := range h.attrs {
+		attrs[k] = v
+	}
+
+	return &SlogHandler{logger: h.logger, attrs: attrs, prefix: h.prefix}
+}
+
+func addAttr(properties map[string]interface{}, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if a.Key != "" {
+			groupPrefix = prefix + a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addAttr(properties, groupPrefix, ga)
+		}
+		return
+	}
+
+	var v interface{}
+
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		v = a.Value.Duration().String()
+	case slog.KindTime:
+		v = a.Value.Time().UTC().Format(time.RFC3339)
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			v = err.Error()
+		} else {
+			v = a.Value.Any()
+		}
+	default:
+		v = a.Value.Any()
+	}
+
+	properties[prefix+a.Key] = v
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"embed"
 	"html/template"
+	"sync/atomic"
 	"time"
 	"github.com/go-mail/mail/v2"
 )
@@ -15,6 +16,8 @@ var templateFS embed.FS
 type Mailer struct {
 	dialer *mail.Dialer
 	sender string
+	sent   *atomic.Int64
+	failed *atomic.Int64
 }
 
 func New(host string, port int, username, password, sender string) Mailer {
@@ -27,6 +30,8 @@ func New(host string, port int, username, password, sender string) Mailer {
 	return Mailer{
 		dialer: dialer,
 		sender: sender,
+		sent:   new(atomic.Int64),
+		failed: new(atomic.Int64),
 	}
 
 }
@@ -67,8 +72,18 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 
 	err = m.dialer.DialAndSend(msg)
 	if err != nil {
+		m.failed.Add(1)
 		return err
 	}
 
+	m.sent.Add(1)
+
 	return nil
+}
+
+
+// Stats returns how many emails have been sent and how many failed to send
+// since the mailer was created.
+func (m Mailer) Stats() (sent, failed int64) {
+	return m.sent.Load(), m.failed.Load()
 }
\ No newline at end of file
@@ -0,0 +1,311 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockKey identifies the PostgreSQL advisory lock held while migrations run,
+// so two instances starting at the same time don't apply the same files.
+const lockKey = 7_142_615_903
+
+var (
+	ErrDirty          = errors.New("database schema is dirty, fix it by hand before migrating")
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrNoChange       = errors.New("no change")
+
+	fileRx = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+type Status struct {
+	Migration
+	Applied bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		parts := fileRx.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || parts == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = m
+		}
+
+		if parts[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrator := &Migrator{db: db}
+
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %d is missing its up file", m.Version)
+		}
+		migrator.migrations = append(migrator.migrations, *m)
+	}
+
+	sort.Slice(migrator.migrations, func(i, j int) bool {
+		return migrator.migrations[i].Version < migrator.migrations[j].Version
+	})
+
+	return migrator, nil
+}
+
+// Latest returns the highest version embedded in the binary, or 0 when there
+// are no migrations.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version currently recorded in schema_migrations. The
+// table layout matches the one used by golang-migrate, so databases migrated
+// with that tool are picked up without changes.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	return version(ctx, m.db)
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{
+			Migration: migration,
+			Applied:   migration.Version <= current,
+		})
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down rolls back the given number of applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("steps must be greater than zero")
+	}
+
+	current, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := m.index(current)
+	if current != 0 && idx < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, current)
+	}
+
+	target := int64(0)
+	if idx-steps >= 0 {
+		target = m.migrations[idx-steps].Version
+	}
+
+	return m.Goto(ctx, target)
+}
+
+// Goto migrates up or down until the schema is at the given version. Version
+// 0 rolls back every migration.
+func (m *Migrator) Goto(ctx context.Context, target int64) ([]Migration, error) {
+	if target != 0 && m.index(target) < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	current, err := version(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == target {
+		return nil, ErrNoChange
+	}
+
+	var applied []Migration
+
+	if target > current {
+		for _, migration := range m.migrations {
+			if migration.Version <= current || migration.Version > target {
+				continue
+			}
+
+			err = apply(ctx, conn, migration.up, migration.Version)
+			if err != nil {
+				return applied, fmt.Errorf("migration %d up: %w", migration.Version, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return applied, nil
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if migration.Version > current || migration.Version <= target {
+			continue
+		}
+
+		previous := int64(0)
+		if i > 0 {
+			previous = m.migrations[i-1].Version
+		}
+
+		err = apply(ctx, conn, migration.down, previous)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d down: %w", migration.Version, err)
+		}
+
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) index(version int64) int {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func ensureTable(ctx context.Context, q querier) error {
+	query := `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+			)
+	`
+
+	_, err := q.ExecContext(ctx, query)
+	return err
+}
+
+func version(ctx context.Context, q querier) (int64, error) {
+	err := ensureTable(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		v     int64
+		dirty bool
+	)
+
+	err = q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	if dirty {
+		return v, ErrDirty
+	}
+
+	return v, nil
+}
+
+// apply runs one migration file and records the resulting version in the same
+// transaction, so a failed file leaves neither the schema nor the version
+// half-changed.
+func apply(ctx context.Context, conn *sql.Conn, body string, newVersion int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if body != "" {
+		_, err = tx.ExecContext(ctx, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if newVersion > 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, newVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -45,8 +45,8 @@ func Matches(value string, rx *regexp.Regexp) bool {
 	return rx.MatchString(value)
 }
 
-func Unique(value []string) bool {
-	uniqueValues := make(map[string]bool)
+func Unique[T comparable](value []T) bool {
+	uniqueValues := make(map[T]bool)
 
 	for _, value := range value {
 		uniqueValues[value] = true
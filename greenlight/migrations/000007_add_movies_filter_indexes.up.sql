@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS movies_year_idx ON movies (year);
+CREATE INDEX IF NOT EXISTS movies_runtime_idx ON movies (runtime);
+CREATE INDEX IF NOT EXISTS movies_created_at_idx ON movies (created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_search_simple_idx;
+DROP INDEX IF EXISTS movies_search_english_idx;
+DROP INDEX IF EXISTS movies_search_russian_idx;
+ALTER TABLE movies DROP COLUMN IF EXISTS search_simple;
+ALTER TABLE movies DROP COLUMN IF EXISTS search_english;
+ALTER TABLE movies DROP COLUMN IF EXISTS search_russian;
+ALTER TABLE movies DROP COLUMN IF EXISTS description;
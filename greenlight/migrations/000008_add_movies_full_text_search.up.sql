@@ -0,0 +1,17 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_simple tsvector GENERATED ALWAYS AS (
+setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', description), 'B')
+) STORED;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_english tsvector GENERATED ALWAYS AS (
+setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', description), 'B')
+) STORED;
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_russian tsvector GENERATED ALWAYS AS (
+setweight(to_tsvector('russian', title), 'A') || setweight(to_tsvector('russian', description), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_simple_idx ON movies USING GIN (search_simple);
+CREATE INDEX IF NOT EXISTS movies_search_english_idx ON movies USING GIN (search_english);
+CREATE INDEX IF NOT EXISTS movies_search_russian_idx ON movies USING GIN (search_russian);
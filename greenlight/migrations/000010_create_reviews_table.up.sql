@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS reviews (
+id bigserial PRIMARY KEY,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+score smallint NOT NULL CHECK (score BETWEEN 1 AND 10),
+body text NOT NULL DEFAULT '',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+version integer NOT NULL DEFAULT 1,
+UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating double precision NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating);
+CREATE INDEX IF NOT EXISTS movies_rating_count_idx ON movies (rating_count);
+
+-- The aggregates are kept up to date by a trigger so that every way of
+-- changing a review, including cascading deletes, is accounted for.
+CREATE OR REPLACE FUNCTION movies_refresh_rating() RETURNS trigger AS $$
+DECLARE
+    target bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        target := OLD.movie_id;
+    ELSE
+        target := NEW.movie_id;
+    END IF;
+
+    UPDATE movies
+    SET average_rating = COALESCE(r.average, 0), rating_count = r.count
+    FROM (SELECT avg(score)::double precision AS average, count(*) AS count FROM reviews WHERE movie_id = target) r
+    WHERE movies.id = target;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reviews_refresh_movie_rating
+AFTER INSERT OR UPDATE OF score OR DELETE ON reviews
+FOR EACH ROW EXECUTE FUNCTION movies_refresh_rating();
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS watchlist_items (
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE TABLE IF NOT EXISTS collections (
+id bigserial PRIMARY KEY,
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+name text NOT NULL,
+description text NOT NULL DEFAULT '',
+public bool NOT NULL DEFAULT false,
+share_token text UNIQUE NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_user_id_idx ON collections (user_id);
+
+CREATE TABLE IF NOT EXISTS collection_items (
+collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+position integer NOT NULL,
+added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (collection_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS collection_items_movie_id_idx ON collection_items (movie_id);
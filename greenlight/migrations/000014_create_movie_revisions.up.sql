@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+id bigserial PRIMARY KEY,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+version integer NOT NULL,
+action text NOT NULL,
+actor_id bigint REFERENCES users ON DELETE SET NULL,
+request_id text NOT NULL DEFAULT '',
+diff jsonb NOT NULL,
+snapshot jsonb NOT NULL,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (movie_id, version)
+);
+
+-- Movies that predate the audit trail get a baseline revision holding their
+-- current state, so they can be reverted back to it later.
+INSERT INTO movie_revisions (movie_id, version, action, diff, snapshot)
+SELECT id, version, 'baseline', '{}', jsonb_build_object(
+'title', title,
+'description', description,
+'year', year,
+'runtime', runtime || ' mins',
+'genres', to_jsonb(genres),
+'deleted', deleted_at IS NOT NULL
+)
+FROM movies
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,7 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+UPDATE movies
+SET updated_at = COALESCE(
+(SELECT max(created_at) FROM movie_revisions WHERE movie_revisions.movie_id = movies.id),
+created_at
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+owner text NOT NULL,
+key text NOT NULL,
+fingerprint bytea NOT NULL,
+status integer,
+header jsonb,
+body bytea,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+expires_at timestamp(0) with time zone NOT NULL,
+PRIMARY KEY (owner, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
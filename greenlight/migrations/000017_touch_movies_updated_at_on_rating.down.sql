@@ -0,0 +1,18 @@
+CREATE OR REPLACE FUNCTION movies_refresh_rating() RETURNS trigger AS $$
+DECLARE
+    target bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        target := OLD.movie_id;
+    ELSE
+        target := NEW.movie_id;
+    END IF;
+
+    UPDATE movies
+    SET average_rating = COALESCE(r.average, 0), rating_count = r.count
+    FROM (SELECT avg(score)::double precision AS average, count(*) AS count FROM reviews WHERE movie_id = target) r
+    WHERE movies.id = target;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
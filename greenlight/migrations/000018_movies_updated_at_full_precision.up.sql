@@ -0,0 +1,3 @@
+-- Last-Modified is only trustworthy once the second it names is over, which
+-- needs the exact time of the write rather than one rounded to the second.
+ALTER TABLE movies ALTER COLUMN updated_at TYPE timestamp with time zone;
@@ -0,0 +1,3 @@
+-- The audit trail has to outlive the movies it describes, so purging a movie
+-- no longer takes its revisions with it.
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_fkey;
@@ -0,0 +1 @@
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_at timestamp with time zone NOT NULL DEFAULT NOW();
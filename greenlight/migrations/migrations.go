@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS holds the SQL migration files so they ship inside the binary.
+//
+//go:embed *.sql
+var FS embed.FS